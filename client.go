@@ -2,8 +2,11 @@ package xroad
 
 import (
 	"bytes"
+	"context"
 	"encoding/xml"
+	"errors"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"time"
 
@@ -24,7 +27,28 @@ type Client struct {
 	SOAPClient
 	IdGenerator // override if you want your own Id generator other than uuid.NewV4
 	Url         string
-	baseHeader  SOAPHeader
+	// Endpoints are the urls of several security servers to fail over between, Url is used when nil.
+	// Another endpoint is tried within the same attempt when the request fails as Endpoints.Failover tells.
+	Endpoints   *Endpoints
+	RetryPolicy RetryPolicy // nil disables retries
+	// XOPStreaming selects how SendXOP and SendXOPFiles send attachments.
+	// Streaming does not help with retries, because files that are not io.Seekers are read into memory.
+	XOPStreaming XOPStreaming
+	// XOPFormat selects between MTOM and SwA for SendXOP and SendXOPFiles.
+	XOPFormat XOPFormat
+	// VerifyRequestHash makes Send fail with ErrRequestHashMissing or ErrRequestHashMismatch
+	// unless the response carries the requestHash of the request, as added by security servers.
+	VerifyRequestHash bool
+	// ValidateResponse makes Send fail with a HeaderMismatchError unless the response header
+	// echoes the Id, UserId, Client and Service of the request, and has a supported protocolVersion.
+	ValidateResponse bool
+	// Middlewares wrap every attempt of Send and its variants.
+	Middlewares []ClientMiddleware
+	// DecodeOptions selects how response attachments are read.
+	// With AttachmentsLazy, read them before closing the response body,
+	// with AttachmentsSpooled, call XOP.Close when done.
+	DecodeOptions DecodeOptions
+	baseHeader    SOAPHeader
 }
 
 func NewSOAPClient() SOAPClient {
@@ -58,10 +82,30 @@ func (c Client) CloneHeader() SOAPHeader {
 		s := *ret.CentralService
 		ret.CentralService = &s
 	}
+	if ret.RequestHash != nil {
+		h := *ret.RequestHash
+		ret.RequestHash = &h
+	}
+	if ret.RepresentedParty != nil {
+		p := *ret.RepresentedParty
+		ret.RepresentedParty = &p
+	}
+	if ret.SecurityServer != nil {
+		s := *ret.SecurityServer
+		ret.SecurityServer = &s
+	}
 	return ret
 }
 
 func (c SOAPClient) NewRequest(url string, header SOAPHeader, body interface{}) (*http.Request, error) {
+	req, err := c.NewRequestWithContext(context.Background(), url, header, body)
+	return req, WrapError(err)
+}
+
+// NewRequestWithContext is like NewRequest but the returned request carries ctx,
+// so that cancellation, deadlines and request-scoped values reach SOAPClient.Do
+// and any http.RoundTripper in between.
+func (c SOAPClient) NewRequestWithContext(ctx context.Context, url string, header SOAPHeader, body interface{}) (*http.Request, error) {
 	e := NewEnvelope(header, body)
 
 	b, err := xml.Marshal(e)
@@ -69,7 +113,7 @@ func (c SOAPClient) NewRequest(url string, header SOAPHeader, body interface{})
 		return nil, WrapError(err)
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(b))
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(b))
 	if err != nil {
 		return nil, WrapError(err)
 	}
@@ -80,50 +124,269 @@ func (c SOAPClient) NewRequest(url string, header SOAPHeader, body interface{})
 }
 
 func (c Client) NewRequest(header SOAPHeader, body interface{}) (*http.Request, error) {
-	id, err := c.IdGenerator()
+	req, err := c.NewRequestWithContext(context.Background(), header, body)
+	return req, WrapError(err)
+}
+
+func (c Client) NewRequestWithContext(ctx context.Context, header SOAPHeader, body interface{}) (*http.Request, error) {
+	header, err := c.newHeader(header)
 	if err != nil {
 		return nil, WrapError(err)
 	}
-	header.Id = id
-	header.fillDefaults()
 
-	req, err := c.SOAPClient.NewRequest(c.Url, header, body)
+	req, err := c.SOAPClient.NewRequestWithContext(ctx, c.Url, header, body)
 	return req, WrapError(err)
 }
 
+// newHeader returns the header with a new message Id and defaults filled.
+func (c Client) newHeader(header SOAPHeader) (SOAPHeader, error) {
+	id, err := c.IdGenerator()
+	if err != nil {
+		return header, WrapError(err)
+	}
+	header.Id = id
+	header.fillDefaults()
+	return header, nil
+}
+
 // Although the response.Body is already read in Send() to parse the response into SOAP,
 // it is the caller's responsibility to close response.Body.
 // The resEnvelope might include XOP files, and those should be read until EOF
 // before closing the response.Body.
+// When the response is a SOAP Fault, Send returns the response together with
+// an error that wraps the SOAPFault; use errors.As to get it.
 func (c Client) Send(header SOAPHeader, body interface{}, resEnvelope *SOAPEnvelope) (*http.Response, error) {
-	req, err := c.NewRequest(header, body)
-	if err != nil {
-		return nil, WrapError(err)
-	}
-	res, err := c.doAndDecode(req, resEnvelope)
+	res, err := c.SendContext(context.Background(), header, body, resEnvelope)
+	return res, WrapError(err)
+}
+
+// SendContext is like Send but aborts the request when ctx is done.
+// If the request failed because ctx was canceled or its deadline exceeded,
+// the returned error wraps ctx.Err(), so errors.Is(err, context.Canceled)
+// tells it apart from transport errors.
+func (c Client) SendContext(ctx context.Context, header SOAPHeader, body interface{}, resEnvelope *SOAPEnvelope) (*http.Response, error) {
+	res, err := c.doWithRetry(ctx, func() (SOAPEnvelope, error) {
+		header, err := c.newHeader(header)
+		return NewEnvelope(header, body), WrapError(err)
+	}, func(url string, e SOAPEnvelope) (*http.Request, error) {
+		req, err := c.SOAPClient.NewRequestWithContext(ctx, url, e.Header, e.Body)
+		return req, WrapError(err)
+	}, resEnvelope)
 	return res, WrapError(err)
 }
 
+// checkResponse verifies the response header against the request of the last attempt,
+// as VerifyRequestHash and ValidateResponse tell.
+func (c Client) checkResponse(sentHeader SOAPHeader, sent []byte, header SOAPHeader) error {
+	if c.VerifyRequestHash {
+		if err := verifyRequestHash(header, sent); err != nil {
+			return WrapError(err)
+		}
+	}
+	if c.ValidateResponse {
+		return WrapError(validateResponseHeader(sentHeader, header))
+	}
+	return nil
+}
+
 // Although the response.Body is already read in Send() to parse the response into SOAP,
 // it is the caller's responsibility to close response.Body.
 // The resEnvelope might include XOP files, and those should be read until EOF
 // before closing the response.Body.
 func (c Client) SendXOP(header SOAPHeader, body FileIncluder, r io.Reader, filename string, resEnvelope *SOAPEnvelope) (*http.Response, error) {
-	req, err := NewXOPRequestFromReader(c.Url, header, body, r, filename)
+	res, err := c.SendXOPContext(context.Background(), header, body, r, filename, resEnvelope)
+	return res, WrapError(err)
+}
+
+// SendXOPContext is like SendXOP but aborts the request when ctx is done.
+// See SendContext for how cancellation is reported.
+// When a RetryPolicy or several Endpoints are set, attachments that are not io.Seekers are read into memory,
+// so that they can be sent again.
+func (c Client) SendXOPContext(ctx context.Context, header SOAPHeader, body FileIncluder, r io.Reader, filename string, resEnvelope *SOAPEnvelope) (*http.Response, error) {
+	files := []XOPFile{{
+		Filename: filename,
+		File:     r,
+	}}
+	res, err := c.SendXOPFiles(ctx, header, body, files, resEnvelope)
+	return res, WrapError(err)
+}
+
+// SendXOPFiles sends body with any number of attachments.
+// Files without a ContentId get a new one. If body implements FilesIncluder or FileIncluder,
+// it is told about the Content-IDs before it is encoded.
+// See SendXOPContext about retries and SendContext about cancellation.
+func (c Client) SendXOPFiles(ctx context.Context, header SOAPHeader, body interface{}, files []XOPFile, resEnvelope *SOAPEnvelope) (*http.Response, error) {
+	xop, err := NewXOP()
 	if err != nil {
 		return nil, WrapError(err)
 	}
-	res, err := c.doAndDecode(req, resEnvelope)
+	xop.Format = c.XOPFormat
+	cids, err := xop.AddFiles(files...)
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	includeFiles(body, cids)
+	if c.replays() {
+		if err := xop.prepareReplay(); err != nil {
+			return nil, WrapError(err)
+		}
+	}
+
+	res, err := c.doWithRetry(ctx, func() (SOAPEnvelope, error) {
+		header, err := c.newHeader(header)
+		if err != nil {
+			return SOAPEnvelope{}, WrapError(err)
+		}
+		if c.replays() {
+			if err := xop.rewind(); err != nil {
+				return SOAPEnvelope{}, WrapError(err)
+			}
+		}
+		e := NewEnvelope(header, body)
+		e.XOP = &xop
+		return e, nil
+	}, func(url string, e SOAPEnvelope) (*http.Request, error) {
+		xop.SOAPEnvelope = e
+		req, err := NewStreamingXOPRequest(ctx, url, e.Header, xop, c.XOPStreaming)
+		return req, WrapError(err)
+	}, resEnvelope)
 	return res, WrapError(err)
 }
 
+// replays reports whether a request might be sent more than once.
+func (c Client) replays() bool {
+	return c.RetryPolicy != nil || (c.Endpoints != nil && c.Endpoints.Len() > 1)
+}
+
+// doWithRetry sends the envelope created by newEnvelope through c.Middlewares until it succeeds
+// or c.RetryPolicy gives up. newEnvelope is called for every attempt,
+// so that each attempt has its own message Id, and encode creates the request of the envelope.
+// The response is checked against the request of the last attempt.
+func (c Client) doWithRetry(ctx context.Context, newEnvelope func() (SOAPEnvelope, error), encode func(string, SOAPEnvelope) (*http.Request, error), resEnvelope *SOAPEnvelope) (*http.Response, error) {
+	var sentHeader SOAPHeader
+	var sent []byte
+	handler := clientChain(ClientHandlerFunc(func(ex *Exchange) error {
+		req, err := encode(ex.URL, ex.Request)
+		if err != nil {
+			return WrapError(err)
+		}
+		ex.HTTPRequest = req
+		sentHeader = ex.Request.Header
+		if c.VerifyRequestHash {
+			// the SOAP part is encoded the same way with or without attachments
+			if sent, err = xml.Marshal(ex.Request); err != nil {
+				return WrapError(err)
+			}
+		}
+		res, err := c.doAndDecode(req, ex.Response)
+		ex.HTTPResponse = res
+		return WrapError(err)
+	}), c.Middlewares)
+
+	for attempt := 1; ; attempt++ {
+		res, err := c.attempt(ctx, handler, attempt, newEnvelope, resEnvelope)
+		if err == nil {
+			return res, WrapError(c.checkResponse(sentHeader, sent, resEnvelope.Header))
+		}
+		if c.RetryPolicy == nil {
+			return res, WrapError(err)
+		}
+		wait, ok := c.RetryPolicy.Backoff(attempt, err)
+		if !ok {
+			return res, WrapError(err)
+		}
+		discard(res)
+		Log.Info("msg", "retrying", "attempt", attempt, "wait", wait, "error", err)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, WrapError(ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// attempt sends the envelope created by newEnvelope to the endpoints in the order of c.Endpoints,
+// until one of them does not fail over.
+func (c Client) attempt(ctx context.Context, handler ClientHandler, attempt int, newEnvelope func() (SOAPEnvelope, error), resEnvelope *SOAPEnvelope) (*http.Response, error) {
+	urls := []string{c.Url}
+	if c.Endpoints != nil {
+		if urls = c.Endpoints.order(); len(urls) == 0 {
+			return nil, WrapError(errors.New("no endpoints"))
+		}
+	}
+	var res *http.Response
+	var err error
+	for i, url := range urls {
+		if i > 0 {
+			discard(res)
+			Log.Info("msg", "failing over", "url", url, "error", err)
+		}
+		var e SOAPEnvelope
+		if e, err = newEnvelope(); err != nil {
+			return nil, WrapError(err)
+		}
+		ex := &Exchange{
+			Context:  ctx,
+			Attempt:  attempt,
+			URL:      url,
+			Request:  e,
+			Response: resEnvelope,
+		}
+		start := time.Now()
+		err = handler.SendSOAP(ex)
+		res = ex.HTTPResponse
+		if c.Endpoints == nil {
+			return res, WrapError(err)
+		}
+		failover := c.Endpoints.failover(err)
+		c.Endpoints.report(url, time.Since(start), failover)
+		if !failover {
+			return res, WrapError(err)
+		}
+	}
+	return res, WrapError(err)
+}
+
+// discard reads and closes the body of res of a failed request, so that the connection can be reused.
+func discard(res *http.Response) {
+	if res != nil {
+		io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
+	}
+}
+
 func (c Client) doAndDecode(req *http.Request, resEnvelope *SOAPEnvelope) (*http.Response, error) {
 	res, err := c.Do(req)
 	if err != nil {
+		// prefer the context error over the *url.Error wrapping it,
+		// so that callers can tell cancellation from transport errors
+		if ctxErr := req.Context().Err(); ctxErr != nil {
+			return nil, WrapError(ctxErr)
+		}
 		return nil, WrapError(err)
 	}
 
-	if err := DecodeResponse(res, resEnvelope); err != nil {
+	if err := DecodeResponseWithOptions(res, resEnvelope, c.DecodeOptions); err != nil {
+		if resEnvelope.XOP != nil {
+			resEnvelope.XOP.Close()
+		}
+		var fault SOAPFault
+		if errors.As(err, &fault) {
+			// the caller might want to look at the status code or headers
+			return res, WrapError(err)
+		}
+		res.Body.Close()
+		if res.StatusCode >= 400 {
+			// a proxy in front of the security server might answer with a non SOAP error page
+			return nil, WrapError(HTTPError{
+				Code:  res.StatusCode,
+				Str:   res.Status,
+				Cause: err,
+			})
+		}
 		return nil, WrapError(err)
 	}
 
@@ -0,0 +1,830 @@
+package xroad
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type testFileBody struct {
+	Include XOPInclude `xml:""`
+}
+
+func (b *testFileBody) IncludeFile(cid string) {
+	b.Include.Href = "cid:" + cid
+}
+
+func TestClientRetry(t *testing.T) {
+	var ids, hrefs, attachments []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e SOAPEnvelope
+		e.Body = &testFileBody{}
+		if err := Decode(r, &e); err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+		ids = append(ids, e.Header.Id)
+		hrefs = append(hrefs, e.Body.(*testFileBody).Include.Href)
+		b, _ := ioutil.ReadAll(e.XOP.Files[0].File)
+		attachments = append(attachments, string(b))
+		if len(ids) < 3 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		WriteSoap(200, e.NewResponseEnvelope(&testBody{Value: "ok"}), w)
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, SOAPHeader{})
+	policy := NewExponentialBackoff(3)
+	policy.Initial = time.Millisecond
+	c.RetryPolicy = policy
+
+	attachment := strings.Repeat("attachment ", 10000)
+	var body testBody
+	res, err := c.SendXOP(c.CloneHeader(), &testFileBody{}, strings.NewReader(attachment), "a.txt", &SOAPEnvelope{Body: &body})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	res.Body.Close()
+
+	if len(ids) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", len(ids))
+	}
+	if ids[0] == ids[1] || ids[1] == ids[2] {
+		t.Errorf("expected a new id per attempt, got %v", ids)
+	}
+	for i := range ids {
+		if hrefs[i] == "" || hrefs[i] != hrefs[0] {
+			t.Errorf("expected the same body in every attempt, got %v", hrefs)
+		}
+		if attachments[i] != attachment {
+			t.Errorf("expected the attachment to be replayed, attempt %d got %d bytes", i+1, len(attachments[i]))
+		}
+	}
+	if body.Value != "ok" {
+		t.Errorf("expected ok, got %q", body.Value)
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	// an empty response is decoded after the server has processed the request
+	res := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/xml"}},
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+	}
+	decodeErr := DecodeResponse(res, &SOAPEnvelope{Body: &testBody{}})
+	if !errors.Is(decodeErr, io.EOF) {
+		t.Fatalf("expected an EOF decode error, got %v", decodeErr)
+	}
+
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&url.Error{Op: "Post", URL: "http://ss", Err: io.EOF}, true},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, true},
+		{HTTPError{Code: http.StatusServiceUnavailable}, true},
+		{SOAPFault{Code: "Server.ClientProxy.NetworkError"}, true},
+		{decodeErr, false},
+		{fmt.Errorf("multipart: %w", io.ErrUnexpectedEOF), false},
+		{HTTPError{Code: http.StatusInternalServerError}, false},
+		{SOAPFault{Code: "Server.ServerProxy.ServiceFailed"}, false},
+		{context.Canceled, false},
+	}
+	for _, test := range tests {
+		if got := IsRetryable(test.err); got != test.want {
+			t.Errorf("%v: expected %v", test.err, test.want)
+		}
+	}
+}
+
+func TestClientVerifyRequestHash(t *testing.T) {
+	mux := NewMux(testBody{})
+	mux.RequestHash = true
+	tamper := false
+	mux.HandleFunc("*", func(w http.ResponseWriter, r *http.Request, e SOAPEnvelope) error {
+		res := e.NewResponseEnvelope(&testBody{Value: "ok"})
+		if tamper {
+			res.Header.RequestHash.Value = NewRequestHash([]byte("other")).Value
+		}
+		return WriteSoap(200, res, w)
+	})
+	srv := httptest.NewServer(ErrorTo500(mux))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, SOAPHeader{Service: &XroadService{ServiceCode: "echo"}})
+	c.VerifyRequestHash = true
+	for _, xop := range []bool{false, true} {
+		var body testBody
+		var err error
+		if xop {
+			_, err = c.SendXOP(c.CloneHeader(), &testFileBody{}, strings.NewReader("attachment"), "a.txt", &SOAPEnvelope{Body: &body})
+		} else {
+			_, err = c.Send(c.CloneHeader(), &testBody{Value: "hello"}, &SOAPEnvelope{Body: &body})
+		}
+		if err != nil {
+			t.Fatalf("xop %v: %s", xop, err)
+		}
+	}
+
+	tamper = true
+	_, err := c.Send(c.CloneHeader(), &testBody{Value: "hello"}, &SOAPEnvelope{Body: &testBody{}})
+	if !errors.Is(err, ErrRequestHashMismatch) {
+		t.Errorf("expected ErrRequestHashMismatch, got %v", err)
+	}
+}
+
+func TestClientValidateResponse(t *testing.T) {
+	var modify func(*SOAPHeader)
+	mux := NewMux(testBody{})
+	mux.HandleFunc("*", func(w http.ResponseWriter, r *http.Request, e SOAPEnvelope) error {
+		res := e.NewResponseEnvelope(&testBody{Value: "ok"})
+		modify(&res.Header)
+		return WriteSoap(200, res, w)
+	})
+	srv := httptest.NewServer(ErrorTo500(mux))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, SOAPHeader{
+		Client:  XroadClient{XRoadInstance: "EE", MemberClass: "GOV", MemberCode: "1", SubsystemCode: "c"},
+		Service: &XroadService{XroadClient: XroadClient{XRoadInstance: "EE", MemberClass: "GOV", MemberCode: "2", SubsystemCode: "p"}, ServiceCode: "get"},
+	})
+	c.ValidateResponse = true
+
+	tests := []struct {
+		modify func(*SOAPHeader)
+		field  string
+	}{
+		{func(h *SOAPHeader) {}, ""},
+		{func(h *SOAPHeader) { h.Id = "other" }, "id"},
+		{func(h *SOAPHeader) { h.Client.MemberCode = "3" }, "client"},
+		{func(h *SOAPHeader) { h.Service.ServiceCode = "put" }, "service"},
+		{func(h *SOAPHeader) { h.ProtocolVersion = "3.1" }, "protocolVersion"},
+	}
+	for _, test := range tests {
+		modify = test.modify
+		_, err := c.Send(c.CloneHeader(), &testBody{}, &SOAPEnvelope{Body: &testBody{}})
+		var mismatch HeaderMismatchError
+		if test.field == "" {
+			if err != nil {
+				t.Errorf("%s", err)
+			}
+		} else if !errors.As(err, &mismatch) || mismatch.Field != test.field {
+			t.Errorf("expected a %s mismatch, got %v", test.field, err)
+		}
+	}
+
+	// the security server resolves a central service to a service of some provider
+	// the Mux dispatches by the service, so it is sent too
+	header := c.CloneHeader()
+	header.CentralService = &XroadCentralService{XRoadInstance: "EE", ServiceCode: "get"}
+	modify = func(h *SOAPHeader) {
+		h.Service = &XroadService{XroadClient: XroadClient{XRoadInstance: "EE", MemberClass: "GOV", MemberCode: "9", SubsystemCode: "p"}, ServiceCode: "get"}
+	}
+	if _, err := c.Send(header, &testBody{}, &SOAPEnvelope{Body: &testBody{}}); err != nil {
+		t.Errorf("%s", err)
+	}
+}
+
+func TestClientMiddlewares(t *testing.T) {
+	var userIds []string
+	mux := NewMux(testBody{})
+	mux.HandleFunc("echo", func(w http.ResponseWriter, r *http.Request, e SOAPEnvelope) error {
+		userIds = append(userIds, e.Header.UserId)
+		return WriteSoap(200, e.NewResponseEnvelope(&testBody{Value: "ok"}), w)
+	})
+	srv := httptest.NewServer(ErrorTo500(mux))
+	defer srv.Close()
+
+	var calls []string
+	record := func(name string) ClientMiddleware {
+		return func(next ClientHandler) ClientHandler {
+			return ClientHandlerFunc(func(ex *Exchange) error {
+				calls = append(calls, name)
+				err := next.SendSOAP(ex)
+				if ex.HTTPRequest == nil || ex.HTTPResponse == nil || ex.HTTPResponse.StatusCode != 200 {
+					t.Errorf("%s: expected the http request and response", name)
+				}
+				return err
+			})
+		}
+	}
+
+	c := NewClient(srv.URL, SOAPHeader{})
+	c.Middlewares = []ClientMiddleware{
+		record("inner"),
+		HeaderDefaults(SOAPHeader{UserId: "EE123", Service: &XroadService{ServiceCode: "echo"}}),
+		record("outer"),
+	}
+	var body testBody
+	if _, err := c.Send(c.CloneHeader(), &testBody{}, &SOAPEnvelope{Body: &body}); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if body.Value != "ok" {
+		t.Errorf("expected ok, got %q", body.Value)
+	}
+	if strings.Join(calls, ",") != "outer,inner" {
+		t.Errorf("expected outer,inner, got %v", calls)
+	}
+
+	header := c.CloneHeader()
+	header.UserId = "EE456"
+	if _, err := c.Send(header, &testBody{}, &SOAPEnvelope{Body: &testBody{}}); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if strings.Join(userIds, ",") != "EE123,EE456" {
+		t.Errorf("expected the default userId only when not set, got %v", userIds)
+	}
+}
+
+// testLogger records the keyvals of each entry, with "level" added.
+type testLogger struct {
+	entries []map[string]interface{}
+}
+
+func (l *testLogger) log(level string, keyvals []interface{}) error {
+	entry := map[string]interface{}{"level": level}
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		entry[fmt.Sprint(keyvals[i])] = keyvals[i+1]
+	}
+	l.entries = append(l.entries, entry)
+	return nil
+}
+
+func (l *testLogger) Debug(keyvals ...interface{}) error { return l.log("debug", keyvals) }
+func (l *testLogger) Info(keyvals ...interface{}) error  { return l.log("info", keyvals) }
+func (l *testLogger) Error(keyvals ...interface{}) error { return l.log("error", keyvals) }
+
+func TestClientLog(t *testing.T) {
+	var hits int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e SOAPEnvelope
+		e.Body = &testBody{}
+		if err := Decode(r, &e); err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+		if atomic.AddInt64(&hits, 1) == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		WriteSoap(200, e.NewResponseEnvelope(&testBody{Value: "ok"}), w)
+	}))
+	defer srv.Close()
+
+	var l testLogger
+	c := NewClient(srv.URL, SOAPHeader{UserId: "EE123"})
+	policy := NewExponentialBackoff(2)
+	policy.Initial = time.Millisecond
+	c.RetryPolicy = policy
+	c.Middlewares = []ClientMiddleware{ClientLog(&l)}
+	if _, err := c.Send(c.CloneHeader(), &testBody{}, &SOAPEnvelope{Body: &testBody{}}); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	if len(l.entries) != 2 {
+		t.Fatalf("expected an entry per attempt, got %v", l.entries)
+	}
+	for i, want := range []struct {
+		level  string
+		status int
+	}{{"error", http.StatusServiceUnavailable}, {"info", http.StatusOK}} {
+		entry := l.entries[i]
+		if entry["level"] != want.level || entry["attempt"] != i+1 || entry["status"] != want.status {
+			t.Errorf("attempt %d: unexpected entry %v", i+1, entry)
+		}
+		if header, ok := entry["header"].(SOAPHeader); !ok || header.UserId != "EE123" {
+			t.Errorf("attempt %d: expected the request header, got %v", i+1, entry["header"])
+		}
+		if _, ok := entry["reqtime"]; !ok {
+			t.Errorf("attempt %d: expected reqtime", i+1)
+		}
+		err, hasErr := entry["error"].(error)
+		if hasErr != (want.level == "error") {
+			t.Errorf("attempt %d: unexpected error %v", i+1, entry["error"])
+		}
+		var httpErr HTTPError
+		if hasErr && (!errors.As(err, &httpErr) || httpErr.Code != http.StatusServiceUnavailable) {
+			t.Errorf("attempt %d: expected the HTTPError, got %v", i+1, err)
+		}
+	}
+}
+
+func TestClientDump(t *testing.T) {
+	var out bytes.Buffer
+	dumpOutput = &out
+	defer func() { dumpOutput = os.Stderr }()
+
+	var attachments []string
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e SOAPEnvelope
+		e.Body = &testFileBody{}
+		if err := Decode(r, &e); err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+		if e.XOP == nil {
+			http.Error(w, "no attachment", http.StatusServiceUnavailable)
+			return
+		}
+		b, _ := ioutil.ReadAll(e.XOP.Files[0].File)
+		mu.Lock()
+		attachments = append(attachments, string(b))
+		mu.Unlock()
+		WriteSoap(200, e.NewResponseEnvelope(&testBody{Value: "pong"}), w)
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, SOAPHeader{UserId: "EE123", Service: &XroadService{ServiceCode: "upload"}})
+	c.Middlewares = []ClientMiddleware{DumpClientResponse, DumpClientRequest}
+	var body testBody
+	res, err := c.SendXOP(c.CloneHeader(), &testFileBody{}, strings.NewReader("attachment"), "a.txt", &SOAPEnvelope{Body: &body})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	res.Body.Close()
+
+	// the dumps leave the request and the response to be read by the server and Send
+	mu.Lock()
+	if len(attachments) != 1 || attachments[0] != "attachment" || body.Value != "pong" {
+		t.Errorf("unexpected attachments %q and response %q", attachments, body.Value)
+	}
+	mu.Unlock()
+	dump := out.String()
+	i := strings.Index(dump, "out:")
+	j := strings.Index(dump, "in:")
+	if i < 0 || j < i {
+		t.Fatalf("expected the request before the response, got %s", dump)
+	}
+	request, response := dump[i:j], dump[j:]
+	if !strings.Contains(request, "<userId") || !strings.Contains(request, "EE123") || strings.Contains(request, "attachment") {
+		t.Errorf("unexpected request dump %s", request)
+	}
+	if !strings.Contains(response, "200 OK") || !strings.Contains(response, "pong") {
+		t.Errorf("unexpected response dump %s", response)
+	}
+
+	// failures are dumped too
+	out.Reset()
+	if _, err := c.Send(c.CloneHeader(), &testBody{}, &SOAPEnvelope{Body: &testBody{}}); err == nil {
+		t.Fatalf("expected an error")
+	}
+	if !strings.Contains(out.String(), "503 Service Unavailable") {
+		t.Errorf("expected the error in the dump, got %s", out.String())
+	}
+}
+
+func TestIsFailover(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&url.Error{Op: "Post", URL: "https://ss", Err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}}, true},
+		{&url.Error{Op: "Post", URL: "https://ss", Err: &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}}, true},
+		{&url.Error{Op: "Post", URL: "https://ss", Err: x509.UnknownAuthorityError{}}, true},
+		{&url.Error{Op: "Post", URL: "https://ss", Err: tls.RecordHeaderError{Msg: "first record does not look like a TLS handshake"}}, true},
+		{NewXroadFault(FaultServer, FaultClientProxy, FaultOutdatedGlobalConf, ""), true},
+		{NewXroadFault(FaultServer, FaultClientProxy, FaultNetworkError, ""), true},
+		// the request might have been processed
+		{&url.Error{Op: "Post", URL: "https://ss", Err: io.EOF}, false},
+		{&url.Error{Op: "Post", URL: "https://ss", Err: &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}}, false},
+		{HTTPError{Code: http.StatusServiceUnavailable}, false},
+		{NewXroadFault(FaultServer, FaultServerProxy, FaultNetworkError, ""), false},
+		{NewXroadFault(FaultServer, FaultServerProxy, FaultServiceFailed, ""), false},
+		{context.DeadlineExceeded, false},
+	}
+	for _, test := range tests {
+		if got := IsFailover(test.err); got != test.want {
+			t.Errorf("%v: expected %v", test.err, test.want)
+		}
+	}
+}
+
+// failoverServer is a provider counting its requests, which answers with its fault if set, or ok.
+type failoverServer struct {
+	*httptest.Server
+	hits  int64
+	mu    sync.Mutex
+	fault *SOAPFault
+}
+
+func newFailoverServer(fault *SOAPFault) *failoverServer {
+	s := &failoverServer{fault: fault}
+	mux := NewMux(testBody{})
+	mux.HandleFunc("*", func(w http.ResponseWriter, r *http.Request, e SOAPEnvelope) error {
+		atomic.AddInt64(&s.hits, 1)
+		s.mu.Lock()
+		fault := s.fault
+		s.mu.Unlock()
+		if fault != nil {
+			return *fault
+		}
+		return WriteSoap(200, e.NewResponseEnvelope(&testBody{Value: "ok"}), w)
+	})
+	s.Server = httptest.NewServer(ErrorTo500(mux))
+	return s
+}
+
+func (s *failoverServer) Hits() int64 {
+	return atomic.LoadInt64(&s.hits)
+}
+
+func (s *failoverServer) setFault(fault *SOAPFault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fault = fault
+}
+
+// deadURL returns the url of a server which refuses connections.
+func deadURL() string {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	return srv.URL
+}
+
+func sendFailover(c Client) (string, error) {
+	var body testBody
+	res, err := c.Send(c.CloneHeader(), &testBody{}, &SOAPEnvelope{Body: &body})
+	if err != nil {
+		return "", err
+	}
+	res.Body.Close()
+	return body.Value, nil
+}
+
+func newFailoverClient(strategy FailoverStrategy, urls ...string) Client {
+	c := NewClient("", SOAPHeader{Service: &XroadService{ServiceCode: "echo"}})
+	c.Endpoints = NewEndpoints(strategy, urls...)
+	return c
+}
+
+func TestClientFailover(t *testing.T) {
+	fault := NewXroadFault(FaultServer, FaultClientProxy, FaultOutdatedGlobalConf, "global conf expired")
+	srv1 := newFailoverServer(&fault)
+	defer srv1.Close()
+	srv2 := newFailoverServer(nil)
+	defer srv2.Close()
+
+	c := newFailoverClient(FailoverPriority, deadURL(), srv1.URL, srv2.URL)
+	for i := 0; i < 2; i++ {
+		if value, err := sendFailover(c); err != nil || value != "ok" {
+			t.Fatalf("expected ok, got %q, %v", value, err)
+		}
+	}
+	if srv1.Hits() != 1 || srv2.Hits() != 2 {
+		t.Errorf("expected the failed endpoints to be skipped until the cooldown, got %d %d", srv1.Hits(), srv2.Hits())
+	}
+	status := c.Endpoints.Status()
+	if status[0].Healthy || status[1].Healthy || !status[2].Healthy || status[2].Latency == 0 {
+		t.Errorf("unexpected status %+v", status)
+	}
+}
+
+func TestClientFailoverProviderFault(t *testing.T) {
+	fault := NewXroadFault(FaultServer, FaultServerProxy, FaultServiceFailed, "failed")
+	srv1 := newFailoverServer(&fault)
+	defer srv1.Close()
+	srv2 := newFailoverServer(nil)
+	defer srv2.Close()
+
+	c := newFailoverClient(FailoverPriority, srv1.URL, srv2.URL)
+	if _, err := sendFailover(c); !IsServiceFailed(err) || srv1.Hits() != 1 || srv2.Hits() != 0 {
+		t.Errorf("expected the fault of the first endpoint, got %v, %d %d", err, srv1.Hits(), srv2.Hits())
+	}
+}
+
+func TestClientFailoverAfterSend(t *testing.T) {
+	// the connection is closed after the request was read
+	var hits1 int64
+	srv1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits1, 1)
+		ioutil.ReadAll(r.Body)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+		conn.Close()
+	}))
+	defer srv1.Close()
+	srv2 := newFailoverServer(nil)
+	defer srv2.Close()
+
+	c := newFailoverClient(FailoverPriority, srv1.URL, srv2.URL)
+	if _, err := sendFailover(c); err == nil || atomic.LoadInt64(&hits1) != 1 || srv2.Hits() != 0 {
+		t.Errorf("expected the error of the first endpoint, got %v, %d %d", err, atomic.LoadInt64(&hits1), srv2.Hits())
+	}
+
+	// unless the request can be sent twice
+	c = newFailoverClient(FailoverPriority, srv1.URL, srv2.URL)
+	c.Endpoints.Failover = IsRetryable
+	if value, err := sendFailover(c); err != nil || value != "ok" || atomic.LoadInt64(&hits1) != 2 || srv2.Hits() != 1 {
+		t.Errorf("expected the second endpoint, got %q, %v, %d %d", value, err, atomic.LoadInt64(&hits1), srv2.Hits())
+	}
+}
+
+func TestClientFailoverAttachments(t *testing.T) {
+	var attachments []string
+	var mu sync.Mutex
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e SOAPEnvelope
+		e.Body = &testFileBody{}
+		if err := Decode(r, &e); err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+		b, _ := ioutil.ReadAll(e.XOP.Files[0].File)
+		mu.Lock()
+		attachments = append(attachments, string(b))
+		mu.Unlock()
+		WriteSoap(200, e.NewResponseEnvelope(&testBody{Value: "ok"}), w)
+	}))
+	defer srv.Close()
+
+	// attachments are sent again to the next endpoint
+	c := newFailoverClient(FailoverPriority, deadURL(), srv.URL)
+	if _, err := c.SendXOP(c.CloneHeader(), &testFileBody{}, strings.NewReader("attachment"), "a.txt", &SOAPEnvelope{Body: &testBody{}}); err != nil {
+		t.Fatalf("%s", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(attachments) != 1 || attachments[0] != "attachment" {
+		t.Errorf("expected the attachment, got %q", attachments)
+	}
+}
+
+func TestClientFailoverRoundRobin(t *testing.T) {
+	var hits []string
+	var mu sync.Mutex
+	var urls []string
+	for _, name := range []string{"a", "b", "c"} {
+		name := name
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			hits = append(hits, name)
+			mu.Unlock()
+			WriteSoap(200, SOAPEnvelope{Body: &testBody{Value: "ok"}}, w)
+		}))
+		defer srv.Close()
+		urls = append(urls, srv.URL)
+	}
+
+	c := newFailoverClient(FailoverRoundRobin, urls...)
+	for i := 0; i < 4; i++ {
+		if _, err := sendFailover(c); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(hits, ",") != "a,b,c,a" {
+		t.Errorf("expected round robin, got %v", hits)
+	}
+}
+
+func TestClientFailoverLeastLatency(t *testing.T) {
+	var slowHits int64
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&slowHits, 1)
+		time.Sleep(20 * time.Millisecond)
+		WriteSoap(200, SOAPEnvelope{Body: &testBody{Value: "ok"}}, w)
+	}))
+	defer slow.Close()
+	fast := newFailoverServer(nil)
+	defer fast.Close()
+
+	// endpoints without a latency are tried first
+	c := newFailoverClient(FailoverLeastLatency, slow.URL, fast.URL)
+	for i := 0; i < 3; i++ {
+		if _, err := sendFailover(c); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+	if atomic.LoadInt64(&slowHits) != 1 || fast.Hits() != 2 {
+		t.Errorf("expected the fastest endpoint, got %d %d", atomic.LoadInt64(&slowHits), fast.Hits())
+	}
+}
+
+func TestClientFailoverCooldown(t *testing.T) {
+	fault := NewXroadFault(FaultServer, FaultClientProxy, FaultOutdatedGlobalConf, "global conf expired")
+	srv1 := newFailoverServer(&fault)
+	defer srv1.Close()
+	srv2 := newFailoverServer(nil)
+	defer srv2.Close()
+
+	c := newFailoverClient(FailoverPriority, srv1.URL, srv2.URL)
+	c.Endpoints.Cooldown = 50 * time.Millisecond
+	send := func() {
+		if _, err := sendFailover(c); err != nil {
+			t.Fatalf("%s", err)
+		}
+	}
+	send()
+	// the first endpoint recovers, but is not tried before the cooldown
+	srv1.setFault(nil)
+	send()
+	if srv1.Hits() != 1 || srv2.Hits() != 2 || c.Endpoints.Status()[0].Healthy {
+		t.Fatalf("expected the first endpoint to be down, got %d %d %+v", srv1.Hits(), srv2.Hits(), c.Endpoints.Status())
+	}
+
+	time.Sleep(c.Endpoints.Cooldown)
+	send()
+	if status := c.Endpoints.Status()[0]; srv1.Hits() != 2 || srv2.Hits() != 2 || !status.Healthy || status.Failures != 0 {
+		t.Errorf("expected the first endpoint after the cooldown, got %d %d %+v", srv1.Hits(), srv2.Hits(), status)
+	}
+}
+
+func TestClientNoEndpoints(t *testing.T) {
+	c := newFailoverClient(FailoverPriority)
+	if _, err := sendFailover(c); err == nil || !strings.Contains(err.Error(), "no endpoints") {
+		t.Errorf("expected an error, got %v", err)
+	}
+}
+
+type testContextKey struct{}
+
+// contextTransport records a value of the context of requests.
+type contextTransport struct {
+	values []interface{}
+}
+
+func (t *contextTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.values = append(t.values, r.Context().Value(testContextKey{}))
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestClientSendContext(t *testing.T) {
+	block := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e SOAPEnvelope
+		e.Body = &testFileBody{}
+		if err := Decode(r, &e); err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+		select {
+		case <-block:
+		case <-r.Context().Done():
+			return
+		}
+		WriteSoap(200, e.NewResponseEnvelope(&testBody{Value: "ok"}), w)
+	}))
+	defer srv.Close()
+	defer close(block)
+
+	transport := &contextTransport{}
+	c := NewClient(srv.URL, SOAPHeader{})
+	c.Transport = transport
+	ctx := context.WithValue(context.Background(), testContextKey{}, "value")
+
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err := c.SendContext(timeout, c.CloneHeader(), &testBody{}, &SOAPEnvelope{Body: &testBody{}})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err = c.SendXOPContext(canceled, c.CloneHeader(), &testFileBody{}, strings.NewReader("attachment"), "a.txt", &SOAPEnvelope{Body: &testBody{}})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+
+	if len(transport.values) != 2 || transport.values[0] != "value" || transport.values[1] != "value" {
+		t.Errorf("expected the context to reach the transport, got %v", transport.values)
+	}
+
+	req, err := c.NewRequestWithContext(ctx, c.CloneHeader(), &testBody{})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if req.Context().Value(testContextKey{}) != "value" {
+		t.Errorf("expected the request to carry the context")
+	}
+
+	// transport errors are not context errors
+	c.Url = "http://127.0.0.1:1"
+	_, err = c.SendContext(ctx, c.CloneHeader(), &testBody{}, &SOAPEnvelope{Body: &testBody{}})
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a transport error, got %v", err)
+	}
+}
+
+// onlyReader hides the Len and Seek methods of a reader, so that its size is unknown.
+type onlyReader struct {
+	io.Reader
+}
+
+type errReader struct {
+	err error
+}
+
+func (r errReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+func TestClientXOPStreaming(t *testing.T) {
+	attachment := make([]byte, 100000)
+	rand.New(rand.NewSource(1)).Read(attachment)
+
+	type received struct {
+		contentLength    int64
+		transferEncoding []string
+		attachment       []byte
+	}
+	requests := make(chan received, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := received{contentLength: r.ContentLength, transferEncoding: r.TransferEncoding}
+		defer func() { requests <- got }()
+		var e SOAPEnvelope
+		e.Body = &testFileBody{}
+		if err := Decode(r, &e); err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+		got.attachment, _ = ioutil.ReadAll(e.XOP.Files[0].File)
+		WriteSoap(200, e.NewResponseEnvelope(&testBody{Value: "ok"}), w)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name      string
+		streaming XOPStreaming
+		r         io.Reader
+		chunked   bool
+	}{
+		{"buffered", XOPBuffered, onlyReader{bytes.NewReader(attachment)}, false},
+		{"chunked", XOPChunked, bytes.NewReader(attachment), true},
+		{"content length", XOPContentLength, bytes.NewReader(attachment), false},
+		// the size is needed for the Content-Length
+		{"content length of unknown size", XOPContentLength, onlyReader{bytes.NewReader(attachment)}, true},
+	}
+	for _, test := range tests {
+		c := NewClient(srv.URL, SOAPHeader{Service: &XroadService{ServiceCode: "upload"}})
+		c.XOPStreaming = test.streaming
+		res, err := c.SendXOP(c.CloneHeader(), &testFileBody{}, test.r, "a.bin", &SOAPEnvelope{Body: &testBody{}})
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		res.Body.Close()
+		got := <-requests
+		if test.chunked {
+			if got.contentLength != -1 || len(got.transferEncoding) != 1 || got.transferEncoding[0] != "chunked" {
+				t.Errorf("%s: expected chunked, got %d %v", test.name, got.contentLength, got.transferEncoding)
+			}
+		} else if got.contentLength <= int64(len(attachment)) || len(got.transferEncoding) != 0 {
+			t.Errorf("%s: expected a Content-Length, got %d %v", test.name, got.contentLength, got.transferEncoding)
+		}
+		if !bytes.Equal(got.attachment, attachment) {
+			t.Errorf("%s: the attachment differs, got %d bytes", test.name, len(got.attachment))
+		}
+	}
+}
+
+func TestClientXOPStreamingError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+	goroutines := runtime.NumGoroutine()
+
+	readErr := errors.New("disk failed")
+	r := io.MultiReader(strings.NewReader("partial"), errReader{readErr})
+	c := NewClient(srv.URL, SOAPHeader{Service: &XroadService{ServiceCode: "upload"}})
+	c.XOPStreaming = XOPChunked
+	if _, err := c.SendXOP(c.CloneHeader(), &testFileBody{}, r, "a.bin", &SOAPEnvelope{Body: &testBody{}}); !errors.Is(err, readErr) {
+		t.Errorf("expected the error of the reader, got %v", err)
+	}
+
+	// the goroutine writing the body has returned
+	c.CloseIdleConnections()
+	srv.CloseClientConnections()
+	for i := 0; runtime.NumGoroutine() > goroutines; i++ {
+		if i == 100 {
+			t.Fatalf("expected %d goroutines, got %d", goroutines, runtime.NumGoroutine())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,154 @@
+package xroad
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Exchange is a request of Client and its response, as seen by ClientMiddlewares.
+// It is created for every attempt. Middlewares may change Request before calling the next handler,
+// which encodes it into HTTPRequest, sends it, and decodes the response into Response.
+type Exchange struct {
+	Context context.Context
+	Attempt int    // starts from 1, see Client.RetryPolicy
+	URL     string // of the security server, see Client.Endpoints
+	Request SOAPEnvelope
+	// Response is the envelope passed to Send, filled after the response is decoded.
+	Response     *SOAPEnvelope
+	HTTPRequest  *http.Request
+	HTTPResponse *http.Response // might be nil if the request failed
+}
+
+type ClientHandler interface {
+	SendSOAP(*Exchange) error
+}
+
+type ClientHandlerFunc func(*Exchange) error
+
+func (f ClientHandlerFunc) SendSOAP(ex *Exchange) error {
+	return f(ex)
+}
+
+// ClientMiddleware wraps the sending of each attempt of Client.
+// Like Mux.Middlewares, the last of Client.Middlewares is the outermost.
+type ClientMiddleware func(ClientHandler) ClientHandler
+
+// clientChain wraps h with middlewares.
+func clientChain(h ClientHandler, middlewares []ClientMiddleware) ClientHandler {
+	for _, middleware := range middlewares {
+		h = middleware(h)
+	}
+	return h
+}
+
+// ClientLog logs the request header and the outcome of every attempt.
+func ClientLog(l Logger) ClientMiddleware {
+	return func(next ClientHandler) ClientHandler {
+		return ClientHandlerFunc(func(ex *Exchange) error {
+			start := time.Now()
+			err := next.SendSOAP(ex)
+			status := 0
+			var httpErr HTTPError
+			if ex.HTTPResponse != nil {
+				status = ex.HTTPResponse.StatusCode
+			} else if errors.As(err, &httpErr) {
+				// the response was closed, as it was not SOAP
+				status = httpErr.Code
+			}
+			keyvals := []interface{}{
+				"header", ex.Request.Header,
+				"attempt", ex.Attempt,
+				"status", status,
+				"reqtime", fmt.Sprintf("%.3f", time.Since(start).Seconds()),
+			}
+			if err != nil {
+				l.Error(append(keyvals, "error", err)...)
+				return WrapError(err)
+			}
+			l.Info(keyvals...)
+			return nil
+		})
+	}
+}
+
+// dumpOutput is where DumpClientRequest and DumpClientResponse write.
+var dumpOutput io.Writer = os.Stderr
+
+// DumpClientRequest writes the request SOAP envelope to stderr, without attachments.
+func DumpClientRequest(next ClientHandler) ClientHandler {
+	return ClientHandlerFunc(func(ex *Exchange) error {
+		b, err := xml.Marshal(ex.Request)
+		if err != nil {
+			return WrapError(err)
+		}
+		fmt.Fprintf(dumpOutput, "\nout:\n%s\n", b)
+		return WrapError(next.SendSOAP(ex))
+	})
+}
+
+// DumpClientResponse writes the response SOAP envelope to stderr, as decoded into Send's resEnvelope,
+// so elements unknown to it are not shown.
+func DumpClientResponse(next ClientHandler) ClientHandler {
+	return ClientHandlerFunc(func(ex *Exchange) error {
+		err := next.SendSOAP(ex)
+		fmt.Fprintf(dumpOutput, "\nin:\n")
+		if ex.HTTPResponse != nil {
+			fmt.Fprintf(dumpOutput, "%s\n", ex.HTTPResponse.Status)
+		}
+		if err != nil {
+			fmt.Fprintf(dumpOutput, "%s\n", err)
+			return WrapError(err)
+		}
+		b, merr := xml.Marshal(ex.Response)
+		if merr != nil {
+			return WrapError(merr)
+		}
+		fmt.Fprintf(dumpOutput, "%s\n", b)
+		return nil
+	})
+}
+
+// HeaderDefaults fills the fields of the request header that are not set from defaults.
+// It is useful with a Client shared by callers that only know the service.
+func HeaderDefaults(defaults SOAPHeader) ClientMiddleware {
+	return func(next ClientHandler) ClientHandler {
+		return ClientHandlerFunc(func(ex *Exchange) error {
+			h := &ex.Request.Header
+			if h.UserId == "" {
+				h.UserId = defaults.UserId
+			}
+			if h.Issue == "" {
+				h.Issue = defaults.Issue
+			}
+			if h.Client.MemberCode == "" {
+				h.Client = defaults.Client
+			}
+			if h.Service == nil && h.CentralService == nil {
+				if defaults.Service != nil {
+					s := *defaults.Service
+					h.Service = &s
+				}
+				if defaults.CentralService != nil {
+					s := *defaults.CentralService
+					h.CentralService = &s
+				}
+			}
+			if h.SecurityServer == nil && defaults.SecurityServer != nil {
+				s := *defaults.SecurityServer
+				h.SecurityServer = &s
+			}
+			if h.RepresentedParty == nil && defaults.RepresentedParty != nil {
+				p := *defaults.RepresentedParty
+				h.RepresentedParty = &p
+			}
+			h.fillDefaults()
+			return WrapError(next.SendSOAP(ex))
+		})
+	}
+}
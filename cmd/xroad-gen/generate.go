@@ -0,0 +1,655 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"go/format"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+var builtinTypes = map[string]string{
+	"anyType":            "string",
+	"anyURI":             "string",
+	"base64Binary":       "[]byte",
+	"boolean":            "bool",
+	"byte":               "int8",
+	"date":               "string",
+	"dateTime":           "string",
+	"decimal":            "string",
+	"double":             "float64",
+	"duration":           "string",
+	"float":              "float32",
+	"hexBinary":          "string",
+	"ID":                 "string",
+	"IDREF":              "string",
+	"int":                "int32",
+	"integer":            "int64",
+	"language":           "string",
+	"long":               "int64",
+	"Name":               "string",
+	"NCName":             "string",
+	"negativeInteger":    "int64",
+	"NMTOKEN":            "string",
+	"nonNegativeInteger": "uint64",
+	"nonPositiveInteger": "int64",
+	"normalizedString":   "string",
+	"positiveInteger":    "uint64",
+	"QName":              "string",
+	"short":              "int16",
+	"string":             "string",
+	"time":               "string",
+	"token":              "string",
+	"unsignedByte":       "uint8",
+	"unsignedInt":        "uint32",
+	"unsignedLong":       "uint64",
+	"unsignedShort":      "uint16",
+}
+
+type field struct {
+	name, typ, tag string
+}
+
+// goType is the result of resolving a xsd type.
+type goType struct {
+	name     string
+	isStruct bool
+	isXOP    bool
+}
+
+type generator struct {
+	pkg, client  string
+	defs         definitions
+	prefixes     map[string]string
+	complexTypes map[string]*complexType
+	simpleTypes  map[string]*simpleType
+	elements     map[string]*element
+	elementNs    map[string]string
+	goNames      map[string]string // "type:"/"element:" + xsd name to go type name
+	used         map[string]bool
+	types        bytes.Buffer
+	// go type name to its first XOPElement field, for IncludeFile
+	xopFields map[string]field
+	// go type name to the go types of its fields
+	deps     map[string][]string
+	warnings []string
+}
+
+func newGenerator(pkg, client string, defs definitions) *generator {
+	g := &generator{
+		pkg:          pkg,
+		client:       client,
+		defs:         defs,
+		prefixes:     make(map[string]string),
+		complexTypes: make(map[string]*complexType),
+		simpleTypes:  make(map[string]*simpleType),
+		elements:     make(map[string]*element),
+		elementNs:    make(map[string]string),
+		goNames:      make(map[string]string),
+		used:         map[string]bool{client: true, "New" + client: true},
+		xopFields:    make(map[string]field),
+		deps:         make(map[string][]string),
+	}
+	g.addPrefixes(defs.Attrs)
+	for i := range defs.Schemas {
+		s := &defs.Schemas[i]
+		g.addPrefixes(s.Attrs)
+		if s.ElementFormDefault != "qualified" {
+			g.warn("schema %s: elementFormDefault is not qualified, child elements are generated as qualified", s.TargetNamespace)
+		}
+		for j := range s.ComplexTypes {
+			g.complexTypes[s.ComplexTypes[j].Name] = &s.ComplexTypes[j]
+		}
+		for j := range s.SimpleTypes {
+			g.simpleTypes[s.SimpleTypes[j].Name] = &s.SimpleTypes[j]
+		}
+		for j := range s.Elements {
+			g.elements[s.Elements[j].Name] = &s.Elements[j]
+			g.elementNs[s.Elements[j].Name] = s.TargetNamespace
+		}
+	}
+
+	// types get the plain names, elements with the same name get a suffix
+	for _, name := range sortedKeys(g.complexTypes) {
+		g.goNames["type:"+name] = g.uniqueName(goName(name), "Type")
+	}
+	for _, name := range sortedKeys(g.simpleTypes) {
+		g.goNames["type:"+name] = g.uniqueName(goName(name), "Type")
+	}
+	for _, name := range sortedKeys(g.elements) {
+		g.goNames["element:"+name] = g.uniqueName(goName(name), "Element")
+	}
+	return g
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]*complexType:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]*simpleType:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	case map[string]*element:
+		for k := range m {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (g *generator) warn(format string, args ...interface{}) {
+	g.warnings = append(g.warnings, fmt.Sprintf(format, args...))
+}
+
+func (g *generator) addPrefixes(attrs []xml.Attr) {
+	for _, a := range attrs {
+		if a.Name.Space == "xmlns" {
+			g.prefixes[a.Name.Local] = a.Value
+		} else if a.Name.Space == "" && a.Name.Local == "xmlns" {
+			g.prefixes[""] = a.Value
+		}
+	}
+}
+
+// resolve splits a QName like xsd:string into namespace and local name.
+func (g *generator) resolve(qname string) (string, string) {
+	if i := strings.Index(qname, ":"); i >= 0 {
+		return g.prefixes[qname[:i]], qname[i+1:]
+	}
+	return g.prefixes[""], qname
+}
+
+// goName converts a xsd name to an exported go identifier.
+func goName(s string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	name := b.String()
+	if name == "" || !unicode.IsLetter([]rune(name)[0]) {
+		name = "X" + name
+	}
+	return name
+}
+
+// uniqueName returns name, or name with suffix and a number if name is taken.
+func (g *generator) uniqueName(name, suffix string) string {
+	candidate := name
+	for i := 1; g.used[candidate]; i++ {
+		candidate = name + suffix
+		if i > 1 {
+			candidate = fmt.Sprintf("%s%s%d", name, suffix, i)
+		}
+	}
+	g.used[candidate] = true
+	return candidate
+}
+
+func (g *generator) generate() ([]byte, error) {
+	for _, name := range sortedKeys(g.simpleTypes) {
+		g.writeSimpleType(g.goNames["type:"+name], g.simpleTypes[name])
+	}
+	for _, name := range sortedKeys(g.complexTypes) {
+		if err := g.writeStruct(g.goNames["type:"+name], "", "", g.complexTypes[name]); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range sortedKeys(g.elements) {
+		if err := g.writeElement(name, g.elements[name]); err != nil {
+			return nil, err
+		}
+	}
+	ops, err := g.writeOperations()
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	b.WriteString("// Code generated by xroad-gen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&b, "package %s\n\n", g.pkg)
+	code := g.types.String() + ops.String()
+	b.WriteString("import (\n")
+	if ops.Len() > 0 {
+		b.WriteString("\"context\"\n\"errors\"\n")
+	}
+	if strings.Contains(code, "xml.Name") {
+		b.WriteString("\"encoding/xml\"\n")
+	}
+	if strings.Contains(code, "io.Reader") {
+		b.WriteString("\"io\"\n")
+	}
+	if strings.Contains(code, "xroad.") {
+		b.WriteString("\n\"github.com/planetway/xroad\"\n")
+	}
+	b.WriteString(")\n\n")
+	b.Write(g.types.Bytes())
+	b.Write(ops.Bytes())
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return b.Bytes(), fmt.Errorf("formatting generated code: %w", err)
+	}
+	return src, nil
+}
+
+func (g *generator) writeSimpleType(name string, st *simpleType) {
+	t := g.typeRef(st.Restriction.Base)
+	fmt.Fprintf(&g.types, "type %s %s\n\n", name, t.name)
+	if t.name != "string" || len(st.Restriction.Enumerations) == 0 {
+		return
+	}
+	g.types.WriteString("const (\n")
+	for _, e := range st.Restriction.Enumerations {
+		fmt.Fprintf(&g.types, "%s %s = %q\n", g.uniqueName(name+goName(e.Value), ""), name, e.Value)
+	}
+	g.types.WriteString(")\n\n")
+}
+
+// typeRef resolves a named xsd type.
+func (g *generator) typeRef(qname string) goType {
+	ns, local := g.resolve(qname)
+	switch ns {
+	case nsXSD:
+		if t, ok := builtinTypes[local]; ok {
+			return goType{name: t}
+		}
+		g.warn("unsupported type %s, using string", qname)
+		return goType{name: "string"}
+	case nsXmime:
+		if local == "base64Binary" {
+			return goType{name: "xroad.XOPElement", isStruct: true, isXOP: true}
+		}
+	}
+	if name, ok := g.goNames["type:"+local]; ok {
+		_, isStruct := g.complexTypes[local]
+		return goType{name: name, isStruct: isStruct}
+	}
+	g.warn("unknown type %s, using string", qname)
+	return goType{name: "string"}
+}
+
+// writeElement writes a struct for a top-level element.
+func (g *generator) writeElement(name string, e *element) error {
+	goName := g.goNames["element:"+name]
+	ns := g.elementNs[name]
+	if e.ComplexType != nil {
+		return g.writeStruct(goName, ns, name, e.ComplexType)
+	}
+
+	fields := []field{{"XMLName", "xml.Name", fmt.Sprintf(`xml:"%s %s"`, ns, name)}}
+	if e.Type != "" {
+		t := g.typeRef(e.Type)
+		if t.isStruct && !t.isXOP {
+			// embed the type, its fields are inlined in xml
+			fields = append(fields, field{"", t.name, ""})
+			g.deps[goName] = append(g.deps[goName], t.name)
+			if f, ok := g.xopFields[t.name]; ok {
+				g.xopFields[goName] = f
+			}
+		} else {
+			fields = append(fields, field{"Value", t.name, `xml:",chardata"`})
+		}
+	}
+	g.writeFields(goName, fields)
+	return nil
+}
+
+func (g *generator) writeFields(name string, fields []field) {
+	fmt.Fprintf(&g.types, "type %s struct {\n", name)
+	for _, f := range fields {
+		if f.tag == "" {
+			fmt.Fprintf(&g.types, "%s %s\n", f.name, f.typ)
+		} else {
+			fmt.Fprintf(&g.types, "%s %s `%s`\n", f.name, f.typ, f.tag)
+		}
+	}
+	g.types.WriteString("}\n\n")
+
+	if f, ok := g.xopFields[name]; ok {
+		fmt.Fprintf(&g.types, "func (x *%s) IncludeFile(cid string) {\n", name)
+		if strings.HasPrefix(f.typ, "[]") {
+			fmt.Fprintf(&g.types, "x.%s = append(x.%s, *xroad.NewXOPElement(cid))\n", f.name, f.name)
+		} else {
+			fmt.Fprintf(&g.types, "x.%s = xroad.NewXOPElement(cid)\n", f.name)
+		}
+		g.types.WriteString("}\n\n")
+	}
+}
+
+// writeStruct writes a struct for a complexType, with a XMLName if elementName is not empty.
+func (g *generator) writeStruct(name, ns, elementName string, ct *complexType) error {
+	var fields []field
+	if elementName != "" {
+		fields = append(fields, field{"XMLName", "xml.Name", fmt.Sprintf(`xml:"%s %s"`, ns, elementName)})
+	}
+	names := map[string]bool{"XMLName": true}
+	more, err := g.complexFields(name, ct, names)
+	if err != nil {
+		return err
+	}
+	fields = append(fields, more...)
+
+	g.writeFields(name, fields)
+	return nil
+}
+
+func (g *generator) complexFields(owner string, ct *complexType, names map[string]bool) ([]field, error) {
+	var fields []field
+	attrs := ct.Attributes
+	if ct.ComplexContent != nil && ct.ComplexContent.Extension != nil {
+		ext := ct.ComplexContent.Extension
+		base := g.typeRef(ext.Base)
+		fields = append(fields, field{"", base.name, ""})
+		names[base.name] = true
+		g.deps[owner] = append(g.deps[owner], base.name)
+		if ext.Sequence != nil {
+			more, err := g.groupFields(owner, ext.Sequence, false, names)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, more...)
+		}
+		attrs = append(attrs, ext.Attributes...)
+	}
+	if ct.SimpleContent != nil && ct.SimpleContent.Extension != nil {
+		ext := ct.SimpleContent.Extension
+		fields = append(fields, field{g.fieldName("Value", names), g.typeRef(ext.Base).name, `xml:",chardata"`})
+		attrs = append(attrs, ext.Attributes...)
+	}
+	for _, grp := range []*group{ct.Sequence, ct.All} {
+		if grp == nil {
+			continue
+		}
+		more, err := g.groupFields(owner, grp, false, names)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, more...)
+	}
+	if ct.Choice != nil {
+		more, err := g.groupFields(owner, ct.Choice, true, names)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, more...)
+	}
+	for _, a := range attrs {
+		tag := a.Name + ",attr"
+		if a.Use != "required" {
+			tag += ",omitempty"
+		}
+		typ := "string"
+		if a.Type != "" {
+			typ = g.typeRef(a.Type).name
+		}
+		fields = append(fields, field{g.fieldName(goName(a.Name), names), typ, fmt.Sprintf(`xml:"%s"`, tag)})
+	}
+	return fields, nil
+}
+
+func (g *generator) groupFields(owner string, grp *group, optional bool, names map[string]bool) ([]field, error) {
+	var fields []field
+	for i := range grp.Elements {
+		f, err := g.elementField(owner, &grp.Elements[i], optional, names)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+	}
+	for i := range grp.Sequences {
+		more, err := g.groupFields(owner, &grp.Sequences[i], optional, names)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, more...)
+	}
+	for i := range grp.Choices {
+		more, err := g.groupFields(owner, &grp.Choices[i], true, names)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, more...)
+	}
+	return fields, nil
+}
+
+func (g *generator) fieldName(name string, names map[string]bool) string {
+	candidate := name
+	for i := 2; names[candidate]; i++ {
+		candidate = fmt.Sprintf("%s%d", name, i)
+	}
+	names[candidate] = true
+	return candidate
+}
+
+// elementField returns the struct field for a child element.
+func (g *generator) elementField(owner string, e *element, optional bool, names map[string]bool) (field, error) {
+	name := e.Name
+	var t goType
+	switch {
+	case e.Ref != "":
+		_, local := g.resolve(e.Ref)
+		ref, ok := g.goNames["element:"+local]
+		if !ok {
+			return field{}, fmt.Errorf("%s: unknown element %s", owner, e.Ref)
+		}
+		name = local
+		t = goType{name: ref, isStruct: true}
+	case e.ComplexType != nil:
+		t = goType{name: g.uniqueName(owner+goName(e.Name), "Type"), isStruct: true}
+		if err := g.writeStruct(t.name, "", "", e.ComplexType); err != nil {
+			return field{}, err
+		}
+	case e.SimpleType != nil:
+		t = g.typeRef(e.SimpleType.Restriction.Base)
+	case e.Type != "":
+		t = g.typeRef(e.Type)
+	default:
+		t = goType{name: "string"}
+	}
+	if t.name == "[]byte" && e.ExpectedContentTypes != "" {
+		t = goType{name: "xroad.XOPElement", isStruct: true, isXOP: true}
+	}
+
+	f := field{name: g.fieldName(goName(name), names)}
+	tag := name
+	switch {
+	case e.MaxOccurs == "unbounded" || (e.MaxOccurs != "" && e.MaxOccurs != "0" && e.MaxOccurs != "1"):
+		f.typ = "[]" + t.name
+	case t.isXOP:
+		f.typ = "*" + t.name
+		tag += ",omitempty"
+	case optional || e.MinOccurs == "0":
+		if t.isStruct {
+			f.typ = "*" + t.name
+		} else {
+			f.typ = t.name
+		}
+		tag += ",omitempty"
+	default:
+		f.typ = t.name
+	}
+	f.tag = fmt.Sprintf(`xml:"%s"`, tag)
+
+	if t.isXOP {
+		if _, ok := g.xopFields[owner]; !ok {
+			g.xopFields[owner] = f
+		}
+	}
+	g.deps[owner] = append(g.deps[owner], t.name)
+	return f, nil
+}
+
+// hasXOP reports whether the go type name has a XOPElement at any depth.
+func (g *generator) hasXOP(name string, seen map[string]bool) bool {
+	if name == "xroad.XOPElement" {
+		return true
+	}
+	if seen[name] {
+		return false
+	}
+	seen[name] = true
+	for _, dep := range g.deps[name] {
+		if g.hasXOP(dep, seen) {
+			return true
+		}
+	}
+	return false
+}
+
+type operation struct {
+	name, version, title string
+	request, response    string // go type names
+}
+
+// messageElement returns the go type of the element of the message's body part.
+func (g *generator) messageElement(qname string) (string, error) {
+	_, local := g.resolve(qname)
+	for _, m := range g.defs.Messages {
+		if m.Name != local {
+			continue
+		}
+		for _, p := range m.Parts {
+			if p.Element == "" {
+				continue
+			}
+			_, el := g.resolve(p.Element)
+			if name, ok := g.goNames["element:"+el]; ok {
+				return name, nil
+			}
+			return "", fmt.Errorf("message %s: unknown element %s", m.Name, p.Element)
+		}
+	}
+	return "", fmt.Errorf("message %s: no part with an element", qname)
+}
+
+func (g *generator) operations() ([]operation, error) {
+	versions := make(map[string]string)
+	for _, b := range g.defs.Bindings {
+		for _, op := range b.Operations {
+			if op.Version != "" {
+				versions[op.Name] = op.Version
+			}
+		}
+	}
+
+	var ops []operation
+	for _, pt := range g.defs.PortTypes {
+		for _, o := range pt.Operations {
+			op := operation{
+				name:    o.Name,
+				version: versions[o.Name],
+				title:   strings.TrimSpace(o.Documentation.Title),
+			}
+			var err error
+			if op.request, err = g.messageElement(o.Input.Message); err != nil {
+				return nil, err
+			}
+			if op.response, err = g.messageElement(o.Output.Message); err != nil {
+				return nil, err
+			}
+			ops = append(ops, op)
+		}
+	}
+	return ops, nil
+}
+
+// writeOperations writes the client and a method for each operation.
+func (g *generator) writeOperations() (*bytes.Buffer, error) {
+	var b bytes.Buffer
+	ops, err := g.operations()
+	if err != nil || len(ops) == 0 {
+		return &b, err
+	}
+
+	c := g.client
+	fmt.Fprintf(&b, "// %s calls the services described in the WSDL.\n", c)
+	b.WriteString("// The Service of the header of the xroad.Client identifies the provider,\n")
+	b.WriteString("// its ServiceCode and ServiceVersion are set by each method.\n")
+	fmt.Fprintf(&b, "type %s struct {\nxroad.Client\n}\n\n", c)
+	fmt.Fprintf(&b, "func New%s(c xroad.Client) %s {\nreturn %s{Client: c}\n}\n\n", c, c, c)
+	fmt.Fprintf(&b, "func (c %s) header(serviceCode, serviceVersion string) (xroad.SOAPHeader, error) {\n", c)
+	b.WriteString("header := c.Client.CloneHeader()\n")
+	b.WriteString("if header.Service == nil {\nreturn header, errors.New(\"service not set in the client header\")\n}\n")
+	b.WriteString("header.Service.ServiceCode = serviceCode\nheader.Service.ServiceVersion = serviceVersion\n")
+	b.WriteString("return header, nil\n}\n\n")
+
+	methods := map[string]bool{"header": true}
+	for _, op := range ops {
+		method := goName(op.name)
+		for i := 2; methods[method]; i++ {
+			method = fmt.Sprintf("%s%d", goName(op.name), i)
+		}
+		methods[method] = true
+		reqBody := g.uniqueName(strings.ToLower(method[:1])+method[1:]+"RequestBody", "")
+		resBody := g.uniqueName(strings.ToLower(method[:1])+method[1:]+"ResponseBody", "")
+		_, reqXOP := g.xopFields[op.request]
+		if !reqXOP && g.hasXOP(op.request, make(map[string]bool)) {
+			g.warn("%s: attachments in nested elements are not set by IncludeFile", op.name)
+		}
+		resXOP := g.hasXOP(op.response, make(map[string]bool))
+
+		fmt.Fprintf(&b, "type %s struct {\nRequest *%s `xml:\"\"`\n}\n\n", reqBody, op.request)
+		if reqXOP {
+			fmt.Fprintf(&b, "func (b %s) IncludeFile(cid string) {\nb.Request.IncludeFile(cid)\n}\n\n", reqBody)
+		}
+		fmt.Fprintf(&b, "type %s struct {\nResponse *%s `xml:\"\"`\n}\n\n", resBody, op.response)
+
+		fmt.Fprintf(&b, "// %s calls the %s service", method, op.name)
+		if op.version != "" {
+			fmt.Fprintf(&b, " version %s", op.version)
+		}
+		b.WriteString(".\n")
+		if op.title != "" {
+			fmt.Fprintf(&b, "// %s\n", strings.Join(strings.Fields(op.title), " "))
+		}
+		if resXOP {
+			b.WriteString("// The attachments of the response are read into memory and returned in the XOP.\n")
+		}
+
+		params := fmt.Sprintf("ctx context.Context, req *%s", op.request)
+		if reqXOP {
+			params += ", r io.Reader, filename string"
+		}
+		results := fmt.Sprintf("*%s, error", op.response)
+		zero := "nil, "
+		if resXOP {
+			results = fmt.Sprintf("*%s, *xroad.XOP, error", op.response)
+			zero = "nil, nil, "
+		}
+		fmt.Fprintf(&b, "func (c %s) %s(%s) (%s) {\n", c, method, params, results)
+		fmt.Fprintf(&b, "header, err := c.header(%q, %q)\nif err != nil {\nreturn %serr\n}\n", op.name, op.version, zero)
+		fmt.Fprintf(&b, "res := %s{Response: &%s{}}\n", resBody, op.response)
+		b.WriteString("envelope := xroad.SOAPEnvelope{Body: &res}\n")
+		if reqXOP {
+			fmt.Fprintf(&b, "httpRes, err := c.Client.SendXOPContext(ctx, header, %s{Request: req}, r, filename, &envelope)\n", reqBody)
+		} else {
+			fmt.Fprintf(&b, "httpRes, err := c.Client.SendContext(ctx, header, %s{Request: req}, &envelope)\n", reqBody)
+		}
+		b.WriteString("if httpRes != nil {\ndefer httpRes.Body.Close()\n}\n")
+		fmt.Fprintf(&b, "if err != nil {\nreturn %serr\n}\n", zero)
+		if resXOP {
+			b.WriteString("if envelope.XOP != nil {\nif err := envelope.XOP.Buffer(); err != nil {\nreturn nil, nil, err\n}\n}\n")
+			b.WriteString("return res.Response, envelope.XOP, nil\n}\n\n")
+		} else {
+			b.WriteString("return res.Response, nil\n}\n\n")
+		}
+	}
+	return &b, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files")
+
+func generateFile(t *testing.T, wsdl string) ([]byte, []string) {
+	b, err := ioutil.ReadFile(wsdl)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	var defs definitions
+	if err := xml.Unmarshal(b, &defs); err != nil {
+		t.Fatalf("%s: %s", wsdl, err)
+	}
+	g := newGenerator("people", "Client", defs)
+	src, err := g.generate()
+	if err != nil {
+		t.Fatalf("%s: %s", wsdl, err)
+	}
+	return src, g.warnings
+}
+
+func TestGenerateGolden(t *testing.T) {
+	files, err := filepath.Glob("testdata/*.wsdl")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no WSDL in testdata: %v", err)
+	}
+	for _, wsdl := range files {
+		src, warnings := generateFile(t, wsdl)
+		if len(warnings) > 0 {
+			t.Errorf("%s: unexpected warnings %v", wsdl, warnings)
+		}
+		golden := strings.TrimSuffix(wsdl, ".wsdl") + ".go.golden"
+		if *update {
+			if err := ioutil.WriteFile(golden, src, 0644); err != nil {
+				t.Fatalf("%s", err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if !bytes.Equal(src, want) {
+			t.Errorf("%s: generated code differs from %s, run go test -update if the change is intended\n%s", wsdl, golden, src)
+		}
+	}
+}
+
+// TestGenerateVet checks that the generated code compiles against the xroad package.
+func TestGenerateVet(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping go vet in short mode")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not found")
+	}
+	files, err := filepath.Glob("testdata/*.wsdl")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	for _, wsdl := range files {
+		src, _ := generateFile(t, wsdl)
+		// inside the module, so that the xroad package resolves to this tree
+		dir, err := ioutil.TempDir("testdata", "vet")
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		defer os.RemoveAll(dir)
+		if err := ioutil.WriteFile(filepath.Join(dir, "people.go"), src, 0644); err != nil {
+			t.Fatalf("%s", err)
+		}
+		out, err := exec.Command(goTool, "vet", "./"+filepath.ToSlash(dir)).CombinedOutput()
+		if err != nil {
+			t.Errorf("%s: go vet failed: %s\n%s", wsdl, err, out)
+		}
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	tests := []struct {
+		name, wsdl, err string
+	}{
+		{
+			"unknown message",
+			`<definitions xmlns:tns="urn:t"><portType><operation name="op"><input message="tns:op"/><output message="tns:opResponse"/></operation></portType></definitions>`,
+			"message tns:op: no part with an element",
+		},
+		{
+			"unknown element",
+			`<definitions xmlns:tns="urn:t"><message name="op"><part name="body" element="tns:op"/></message>` +
+				`<portType><operation name="op"><input message="tns:op"/><output message="tns:op"/></operation></portType></definitions>`,
+			"message op: unknown element tns:op",
+		},
+	}
+	for _, test := range tests {
+		var defs definitions
+		if err := xml.Unmarshal([]byte(test.wsdl), &defs); err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		_, err := newGenerator("people", "Client", defs).generate()
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%s: expected error %q, got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestGenerateWarnings(t *testing.T) {
+	wsdl := `<definitions xmlns:xsd="http://www.w3.org/2001/XMLSchema"><types><xsd:schema targetNamespace="urn:t">` +
+		`<xsd:element name="e" type="xsd:gYear"/></xsd:schema></types></definitions>`
+	var defs definitions
+	if err := xml.Unmarshal([]byte(wsdl), &defs); err != nil {
+		t.Fatalf("%s", err)
+	}
+	g := newGenerator("people", "Client", defs)
+	if _, err := g.generate(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(g.warnings) != 2 || !strings.Contains(g.warnings[0], "elementFormDefault") || !strings.Contains(g.warnings[1], "xsd:gYear") {
+		t.Errorf("unexpected warnings %v", g.warnings)
+	}
+}
@@ -0,0 +1,62 @@
+// xroad-gen generates Go types and client wrappers from a X-Road service WSDL.
+//
+//	xroad-gen -pkg people -o people.go people.wsdl
+//
+// The generated request and response types can be used as the body of xroad.Client.Send,
+// and for each operation a method on the generated client sends the request with
+// the service code and version of the operation.
+package main
+
+import (
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+func main() {
+	pkg := flag.String("pkg", "main", "package name of the generated code")
+	out := flag.String("o", "", "output file, stdout if empty")
+	client := flag.String("client", "Client", "name of the generated client type")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] file.wsdl\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(flag.Arg(0), *pkg, *client, *out); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(in, pkg, client, out string) error {
+	b, err := ioutil.ReadFile(in)
+	if err != nil {
+		return err
+	}
+	var defs definitions
+	if err := xml.Unmarshal(b, &defs); err != nil {
+		return fmt.Errorf("%s: %w", in, err)
+	}
+
+	g := newGenerator(pkg, client, defs)
+	src, err := g.generate()
+	if err != nil {
+		return err
+	}
+	for _, w := range g.warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+	}
+
+	if out == "" {
+		_, err = os.Stdout.Write(src)
+		return err
+	}
+	return ioutil.WriteFile(out, src, 0644)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/xml"
+)
+
+const (
+	nsXSD   = "http://www.w3.org/2001/XMLSchema"
+	nsXmime = "http://www.w3.org/2005/05/xmlmime"
+	nsXroad = "http://x-road.eu/xsd/xroad.xsd"
+)
+
+// The subset of WSDL 1.1 and XML Schema used by X-Road services.
+
+type definitions struct {
+	Name            string     `xml:"name,attr"`
+	TargetNamespace string     `xml:"targetNamespace,attr"`
+	Attrs           []xml.Attr `xml:",any,attr"`
+	Schemas         []schema   `xml:"types>schema"`
+	Messages        []message  `xml:"message"`
+	PortTypes       []portType `xml:"portType"`
+	Bindings        []binding  `xml:"binding"`
+}
+
+type message struct {
+	Name  string `xml:"name,attr"`
+	Parts []struct {
+		Name    string `xml:"name,attr"`
+		Element string `xml:"element,attr"`
+	} `xml:"part"`
+}
+
+type portType struct {
+	Name       string `xml:"name,attr"`
+	Operations []struct {
+		Name          string `xml:"name,attr"`
+		Documentation struct {
+			Title string `xml:"http://x-road.eu/xsd/xroad.xsd title"`
+		} `xml:"documentation"`
+		Input struct {
+			Message string `xml:"message,attr"`
+		} `xml:"input"`
+		Output struct {
+			Message string `xml:"message,attr"`
+		} `xml:"output"`
+	} `xml:"operation"`
+}
+
+type binding struct {
+	Name       string `xml:"name,attr"`
+	Operations []struct {
+		Name    string `xml:"name,attr"`
+		Version string `xml:"http://x-road.eu/xsd/xroad.xsd version"`
+	} `xml:"operation"`
+}
+
+type schema struct {
+	TargetNamespace    string        `xml:"targetNamespace,attr"`
+	ElementFormDefault string        `xml:"elementFormDefault,attr"`
+	Attrs              []xml.Attr    `xml:",any,attr"`
+	Elements           []element     `xml:"element"`
+	ComplexTypes       []complexType `xml:"complexType"`
+	SimpleTypes        []simpleType  `xml:"simpleType"`
+}
+
+type element struct {
+	Name                 string       `xml:"name,attr"`
+	Type                 string       `xml:"type,attr"`
+	Ref                  string       `xml:"ref,attr"`
+	MinOccurs            string       `xml:"minOccurs,attr"`
+	MaxOccurs            string       `xml:"maxOccurs,attr"`
+	ExpectedContentTypes string       `xml:"http://www.w3.org/2005/05/xmlmime expectedContentTypes,attr"`
+	ComplexType          *complexType `xml:"complexType"`
+	SimpleType           *simpleType  `xml:"simpleType"`
+}
+
+type attribute struct {
+	Name string `xml:"name,attr"`
+	Type string `xml:"type,attr"`
+	Use  string `xml:"use,attr"`
+}
+
+type group struct {
+	Elements  []element `xml:"element"`
+	Sequences []group   `xml:"sequence"`
+	Choices   []group   `xml:"choice"`
+}
+
+type extension struct {
+	Base       string      `xml:"base,attr"`
+	Sequence   *group      `xml:"sequence"`
+	Attributes []attribute `xml:"attribute"`
+}
+
+type complexType struct {
+	Name          string      `xml:"name,attr"`
+	Sequence      *group      `xml:"sequence"`
+	All           *group      `xml:"all"`
+	Choice        *group      `xml:"choice"`
+	Attributes    []attribute `xml:"attribute"`
+	SimpleContent *struct {
+		Extension *extension `xml:"extension"`
+	} `xml:"simpleContent"`
+	ComplexContent *struct {
+		Extension *extension `xml:"extension"`
+	} `xml:"complexContent"`
+}
+
+type simpleType struct {
+	Name        string `xml:"name,attr"`
+	Restriction struct {
+		Base         string `xml:"base,attr"`
+		Enumerations []struct {
+			Value string `xml:"value,attr"`
+		} `xml:"enumeration"`
+	} `xml:"restriction"`
+}
@@ -8,8 +8,11 @@ import (
 )
 
 type ReqConfig struct {
-	Url        string     `json:"url" mapstructure:"url"`
-	SOAPHeader SOAPHeader `json:"header" yaml:"header" mapstructure:"header"`
+	Url        string           `json:"url" mapstructure:"url"`
+	Urls       []string         `json:"urls,omitempty" yaml:"urls" mapstructure:"urls"` // several security servers to fail over between
+	Failover   FailoverStrategy `json:"failover,omitempty" yaml:"failover" mapstructure:"failover"`
+	SOAPHeader SOAPHeader       `json:"header" yaml:"header" mapstructure:"header"`
+	TLS        *TLSConfig       `json:"tls,omitempty" yaml:"tls" mapstructure:"tls"`
 }
 
 func LoadConfig(filename string) (*ReqConfig, error) {
@@ -26,6 +29,26 @@ func LoadConfig(filename string) (*ReqConfig, error) {
 	return &config, WrapError(err)
 }
 
+// NewClientFromConfig creates a Client of the configuration, connecting with c.TLS if set.
+func NewClientFromConfig(c ReqConfig) (Client, error) {
+	ret := NewClient(c.Url, c.SOAPHeader)
+	if len(c.Urls) > 0 {
+		ret.Endpoints = NewEndpoints(c.Failover, c.Urls...)
+		if ret.Url == "" {
+			// for the metaservices
+			ret.Url = c.Urls[0]
+		}
+	}
+	if c.TLS != nil {
+		client, err := NewTLSSOAPClient(*c.TLS)
+		if err != nil {
+			return ret, WrapError(err)
+		}
+		ret.SOAPClient = client
+	}
+	return ret, nil
+}
+
 type ConfigChecker func(c ReqConfig) error
 
 func (c ReqConfig) Check(checks ...ConfigChecker) error {
@@ -38,12 +61,18 @@ func (c ReqConfig) Check(checks ...ConfigChecker) error {
 }
 
 func URLCheck(c ReqConfig) error {
-	if c.Url == "" {
+	if c.Url == "" && len(c.Urls) == 0 {
 		return WrapError(errors.New("url empty"))
 	}
-	_, err := url.Parse(c.Url)
-	if err != nil {
-		return WrapError(err)
+	if c.Url != "" {
+		if _, err := url.Parse(c.Url); err != nil {
+			return WrapError(err)
+		}
+	}
+	for _, u := range c.Urls {
+		if _, err := url.Parse(u); err != nil {
+			return WrapError(err)
+		}
 	}
 	return nil
 }
@@ -121,8 +150,20 @@ func ClientCheck(c ReqConfig) error {
 	if c.SOAPHeader.Client.MemberCode == "" {
 		return WrapError(errors.New("Client MemberCode empty"))
 	}
-	if c.SOAPHeader.Client.SubsystemCode == "" {
+	// members send requests without a subsystem
+	if c.SOAPHeader.Client.SubsystemCode == "" && c.SOAPHeader.Client.ObjectType != ObjectTypeMember {
 		return WrapError(errors.New("Client SubsystemCode empty"))
 	}
 	return nil
 }
+
+func RepresentedPartyCheck(c ReqConfig) error {
+	party := c.SOAPHeader.RepresentedParty
+	if party == nil {
+		return WrapError(errors.New("RepresentedParty empty"))
+	}
+	if party.PartyCode == "" {
+		return WrapError(errors.New("RepresentedParty PartyCode empty"))
+	}
+	return nil
+}
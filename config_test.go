@@ -1,12 +1,58 @@
 package xroad
 
 import (
+	"encoding/json"
+	"encoding/xml"
 	"testing"
 )
 
 func TestConfig(t *testing.T) {
-	_, err := LoadConfig("config.json.template")
+	c, err := LoadConfig("config.json.template")
 	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(c.Urls) != 2 || c.Failover != FailoverPriority || URLCheck(*c) != nil {
+		t.Errorf("unexpected urls %v", c.Urls)
+	}
+	if c.TLS == nil || c.TLS.CertFile == "" || c.TLS.CAFile == "" || c.TLS.MinVersion != "1.2" {
+		t.Errorf("unexpected TLS config %+v", c.TLS)
+	}
+}
+
+func TestRepresentedParty(t *testing.T) {
+	var c ReqConfig
+	if err := json.Unmarshal([]byte(`{"header":{"representedParty":{"partyClass":"COM","partyCode":"123"}}}`), &c); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := c.Check(RepresentedPartyCheck); err != nil {
 		t.Errorf("%s", err)
 	}
+
+	b, err := xml.Marshal(NewEnvelope(c.SOAPHeader, &testBody{}))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	var e SOAPEnvelope
+	e.Body = &testBody{}
+	if err := xml.Unmarshal(b, &e); err != nil {
+		t.Fatalf("%s", err)
+	}
+	party, ok := e.RepresentedParty()
+	if !ok || party != *c.SOAPHeader.RepresentedParty {
+		t.Errorf("unexpected represented party %v in %s", party, b)
+	}
+
+	if err := (ReqConfig{}).Check(RepresentedPartyCheck); err == nil {
+		t.Errorf("expected an error without representedParty")
+	}
+}
+
+func TestFailoverConfig(t *testing.T) {
+	var config ReqConfig
+	if err := json.Unmarshal([]byte(`{"urls":["a","b"],"failover":"least-latency"}`), &config); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if config.Failover != FailoverLeastLatency || config.Check(URLCheck) != nil {
+		t.Errorf("unexpected config %+v", config)
+	}
 }
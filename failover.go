@@ -0,0 +1,228 @@
+package xroad
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// FailoverStrategy selects the order in which Endpoints are tried.
+type FailoverStrategy int
+
+const (
+	// FailoverPriority tries the endpoints in the order given.
+	FailoverPriority FailoverStrategy = iota
+	// FailoverRoundRobin starts from the next endpoint on every request.
+	FailoverRoundRobin
+	// FailoverLeastLatency tries the endpoint with the lowest average response time first.
+	FailoverLeastLatency
+)
+
+var failoverStrategies = map[FailoverStrategy]string{
+	FailoverPriority:     "priority",
+	FailoverRoundRobin:   "round-robin",
+	FailoverLeastLatency: "least-latency",
+}
+
+func (s FailoverStrategy) String() string {
+	return failoverStrategies[s]
+}
+
+func (s FailoverStrategy) MarshalText() ([]byte, error) {
+	name, ok := failoverStrategies[s]
+	if !ok {
+		return nil, WrapError(fmt.Errorf("invalid failover strategy: %d", int(s)))
+	}
+	return []byte(name), nil
+}
+
+func (s *FailoverStrategy) UnmarshalText(b []byte) error {
+	for strategy, name := range failoverStrategies {
+		if name == string(b) {
+			*s = strategy
+			return nil
+		}
+	}
+	return WrapError(fmt.Errorf("invalid failover strategy: %s", b))
+}
+
+// failoverFaults are the codes of faults from the consumer's security server,
+// which another security server of the member might not have.
+var failoverFaults = map[string]bool{
+	FaultDatabaseError:           true,
+	FaultInternalError:           true,
+	FaultIOError:                 true,
+	FaultNetworkError:            true,
+	FaultOutdatedGlobalConf:      true,
+	FaultSslAuthenticationFailed: true,
+	FaultTimestampValidation:     true,
+}
+
+// IsFailover reports whether err tells that the request did not reach the provider,
+// so that another security server can be tried without sending it twice:
+// errors of dialing the security server or of the TLS handshake,
+// and faults of the consumer's security server like OutdatedGlobalConf.
+// Errors after the request was sent, like an EOF or a timeout of the response, are not failed over,
+// set Endpoints.Failover to IsRetryable for requests which are safe to send again.
+func IsFailover(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if fault, ok := asFault(err); ok {
+		return fault.Source() == FaultFromClientProxy && failoverFaults[fault.XroadCode()]
+	}
+	var oe *net.OpError
+	if (errors.As(err, &oe) && oe.Op == "dial") || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var recordErr tls.RecordHeaderError
+	var authorityErr x509.UnknownAuthorityError
+	var invalidErr x509.CertificateInvalidError
+	var hostnameErr x509.HostnameError
+	return errors.As(err, &recordErr) || errors.As(err, &authorityErr) ||
+		errors.As(err, &invalidErr) || errors.As(err, &hostnameErr)
+}
+
+// Endpoints are the urls of the security servers of a Client, with their health.
+// An endpoint that failed is tried after the healthy ones until Cooldown passes.
+// Endpoints are safe for concurrent use, and shared by copies of the Client.
+type Endpoints struct {
+	Strategy FailoverStrategy
+	Cooldown time.Duration
+	// Failover classifies errors, IsFailover is used when nil.
+	// It might widen IsFailover, when the provider can take a request twice.
+	Failover func(error) bool
+
+	mu        sync.Mutex
+	endpoints []*endpoint
+	next      int // of round robin
+}
+
+type endpoint struct {
+	url       string
+	failures  int // in a row
+	downUntil time.Time
+	latency   time.Duration // moving average
+}
+
+// EndpointStatus is the health of an endpoint.
+type EndpointStatus struct {
+	Url      string
+	Healthy  bool
+	Failures int
+	Latency  time.Duration
+}
+
+func NewEndpoints(strategy FailoverStrategy, urls ...string) *Endpoints {
+	e := &Endpoints{
+		Strategy: strategy,
+		Cooldown: 30 * time.Second,
+	}
+	for _, url := range urls {
+		e.endpoints = append(e.endpoints, &endpoint{url: url})
+	}
+	return e
+}
+
+func (e *Endpoints) Len() int {
+	return len(e.endpoints)
+}
+
+// Status returns the health of the endpoints, in the order given.
+func (e *Endpoints) Status() []EndpointStatus {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now()
+	ret := make([]EndpointStatus, 0, len(e.endpoints))
+	for _, ep := range e.endpoints {
+		ret = append(ret, EndpointStatus{
+			Url:      ep.url,
+			Healthy:  !now.Before(ep.downUntil),
+			Failures: ep.failures,
+			Latency:  ep.latency,
+		})
+	}
+	return ret
+}
+
+// order returns the urls to try for a request,
+// the healthy ones ordered by the strategy followed by the failed ones, the earliest to recover first.
+func (e *Endpoints) order() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now()
+	var healthy, down []*endpoint
+	for _, ep := range e.endpoints {
+		if now.Before(ep.downUntil) {
+			down = append(down, ep)
+		} else {
+			healthy = append(healthy, ep)
+		}
+	}
+
+	switch e.Strategy {
+	case FailoverRoundRobin:
+		if len(healthy) > 0 {
+			i := e.next % len(healthy)
+			healthy = append(append([]*endpoint{}, healthy[i:]...), healthy[:i]...)
+			e.next++
+		}
+	case FailoverLeastLatency:
+		sort.SliceStable(healthy, func(i, j int) bool {
+			return healthy[i].latency < healthy[j].latency
+		})
+	}
+	sort.SliceStable(down, func(i, j int) bool {
+		return down[i].downUntil.Before(down[j].downUntil)
+	})
+
+	ret := make([]string, 0, len(e.endpoints))
+	for _, ep := range append(healthy, down...) {
+		ret = append(ret, ep.url)
+	}
+	return ret
+}
+
+func (e *Endpoints) failover(err error) bool {
+	if err == nil {
+		return false
+	}
+	if e.Failover != nil {
+		return e.Failover(err)
+	}
+	return IsFailover(err)
+}
+
+// report records the outcome of a request to url, which took elapsed.
+func (e *Endpoints) report(url string, elapsed time.Duration, failed bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, ep := range e.endpoints {
+		if ep.url != url {
+			continue
+		}
+		if failed {
+			ep.failures++
+			ep.downUntil = time.Now().Add(e.Cooldown)
+			return
+		}
+		ep.failures = 0
+		ep.downUntil = time.Time{}
+		if ep.latency == 0 {
+			ep.latency = elapsed
+		} else {
+			ep.latency = (ep.latency*7 + elapsed*3) / 10
+		}
+		return
+	}
+}
@@ -0,0 +1,221 @@
+package xroad
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Fault codes are dot separated.
+// The first part tells who is to blame and the optional second part tells which
+// security server component detected the error, followed by the error code
+// and optional sub codes.
+// ex: Server.ClientProxy.NetworkError, Server.ServerProxy.ServiceFailed.InternalError
+// https://github.com/nordic-institute/X-Road/blob/develop/doc/Protocols/pr-mess_x-road_message_protocol.md#25-soap-faults
+const (
+	FaultClient = "Client"
+	FaultServer = "Server"
+
+	FaultClientProxy = "ClientProxy"
+	FaultServerProxy = "ServerProxy"
+)
+
+// Error codes used by the security server.
+// See ErrorCodes.java in the X-Road source.
+const (
+	FaultAccessDenied            = "AccessDenied"
+	FaultCertValidation          = "CertValidation"
+	FaultDatabaseError           = "DatabaseError"
+	FaultDuplicateHeaderField    = "DuplicateHeaderField"
+	FaultHttpError               = "HttpError"
+	FaultInconsistentResponse    = "InconsistentResponse"
+	FaultInternalError           = "InternalError"
+	FaultInvalidClientIdentifier = "InvalidClientIdentifier"
+	FaultInvalidContentType      = "InvalidContentType"
+	FaultInvalidMessage          = "InvalidMessage"
+	FaultInvalidProtocolVersion  = "InvalidProtocolVersion"
+	FaultInvalidRequest          = "InvalidRequest"
+	FaultInvalidSecurityServer   = "InvalidSecurityServer"
+	FaultInvalidSignatureValue   = "InvalidSignatureValue"
+	FaultInvalidSoap             = "InvalidSoap"
+	FaultInvalidXml              = "InvalidXml"
+	FaultIOError                 = "IOError"
+	FaultMissingHeaderField      = "MissingHeaderField"
+	FaultMissingSignature        = "MissingSignature"
+	FaultMissingSoap             = "MissingSoap"
+	FaultNetworkError            = "NetworkError"
+	FaultOutdatedGlobalConf      = "OutdatedGlobalConf"
+	FaultServiceDisabled         = "ServiceDisabled"
+	FaultServiceFailed           = "ServiceFailed"
+	FaultSslAuthenticationFailed = "SslAuthenticationFailed"
+	FaultTimestampValidation     = "TimestampValidation"
+	FaultUnknownMember           = "UnknownMember"
+	FaultUnknownService          = "UnknownService"
+)
+
+// FaultSource tells which party produced a SOAPFault.
+type FaultSource int
+
+const (
+	// The fault was produced by the service provider's information system.
+	FaultFromProvider FaultSource = iota
+	// The fault was produced by the consumer's security server.
+	FaultFromClientProxy
+	// The fault was produced by the provider's security server.
+	FaultFromServerProxy
+)
+
+func (s FaultSource) String() string {
+	switch s {
+	case FaultFromClientProxy:
+		return "ClientProxy"
+	case FaultFromServerProxy:
+		return "ServerProxy"
+	}
+	return "Provider"
+}
+
+// NewXroadFault creates a SOAPFault with a code in the X-Road format.
+// ex: NewXroadFault(FaultServer, FaultServerProxy, FaultServiceFailed, "...")
+// has the faultcode Server.ServerProxy.ServiceFailed.
+// proxy can be empty.
+func NewXroadFault(prefix, proxy, code, fault string) SOAPFault {
+	parts := []string{prefix}
+	if proxy != "" {
+		parts = append(parts, proxy)
+	}
+	parts = append(parts, code)
+	return SOAPFault{
+		Code:   strings.Join(parts, "."),
+		String: fault,
+	}
+}
+
+// faultCodeParts returns the fault code split by '.'
+// with an optional namespace prefix like "SOAP-ENV:" removed.
+func faultCodeParts(code string) []string {
+	if i := strings.LastIndex(code, ":"); i >= 0 {
+		code = code[i+1:]
+	}
+	return strings.Split(code, ".")
+}
+
+// XroadCode returns the X-Road error code of the fault, without the
+// Client/Server prefix, the proxy and sub codes.
+// ex: "ServiceFailed" for Server.ServerProxy.ServiceFailed.InternalError
+// It returns an empty string if the fault code has no X-Road error code.
+func (s SOAPFault) XroadCode() string {
+	parts := faultCodeParts(s.Code)
+	if len(parts) < 2 {
+		return ""
+	}
+	if parts[1] == FaultClientProxy || parts[1] == FaultServerProxy {
+		if len(parts) < 3 {
+			return ""
+		}
+		return parts[2]
+	}
+	return parts[1]
+}
+
+// HasCode reports whether the fault code contains code as one of its dot separated parts.
+// ex: a fault with code Server.ServerProxy.ServiceFailed.InternalError
+// has codes ServiceFailed and InternalError.
+func (s SOAPFault) HasCode(code string) bool {
+	for _, part := range faultCodeParts(s.Code) {
+		if part == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Source classifies where the fault came from, by the proxy part of the fault code.
+// Faults without one come from the provider, even if their code is one the security server uses.
+func (s SOAPFault) Source() FaultSource {
+	parts := faultCodeParts(s.Code)
+	if len(parts) < 2 {
+		return FaultFromProvider
+	}
+	switch parts[1] {
+	case FaultClientProxy:
+		return FaultFromClientProxy
+	case FaultServerProxy:
+		return FaultFromServerProxy
+	}
+	return FaultFromProvider
+}
+
+// FromSecurityServer reports whether the fault was produced by either security server
+// rather than by the provider's information system.
+func (s SOAPFault) FromSecurityServer() bool {
+	return s.Source() != FaultFromProvider
+}
+
+// asFault returns the SOAPFault in err, or a RESTError as a SOAPFault,
+// because their types are the same fault codes.
+func asFault(err error) (SOAPFault, bool) {
+	var fault SOAPFault
+	if errors.As(err, &fault) {
+		return fault, true
+	}
+	var restErr RESTError
+	if errors.As(err, &restErr) {
+		return SOAPFault{
+			Code:   restErr.Type,
+			String: restErr.Message,
+		}, true
+	}
+	return fault, false
+}
+
+// IsFaultCode reports whether err is a SOAPFault or RESTError having code as one of its fault code parts.
+func IsFaultCode(err error, code string) bool {
+	fault, ok := asFault(err)
+	return ok && fault.HasCode(code)
+}
+
+// IsAccessDenied reports whether err is a fault telling that the client
+// is not allowed to access the service.
+func IsAccessDenied(err error) bool {
+	return IsFaultCode(err, FaultAccessDenied)
+}
+
+// IsServiceFailed reports whether err is a fault telling that the
+// provider's information system failed to answer.
+func IsServiceFailed(err error) bool {
+	return IsFaultCode(err, FaultServiceFailed)
+}
+
+// IsNetworkError reports whether err is a fault telling that
+// a security server could not connect to the other party.
+func IsNetworkError(err error) bool {
+	return IsFaultCode(err, FaultNetworkError)
+}
+
+// IsUnknownService reports whether err is a fault telling that the service
+// or its member is not known to the security server.
+func IsUnknownService(err error) bool {
+	return IsFaultCode(err, FaultUnknownService) || IsFaultCode(err, FaultUnknownMember)
+}
+
+// IsTimeout reports whether err is a timeout of the request:
+// an exceeded context deadline, a network timeout or HTTP 504 from a proxy.
+// X-Road has no fault code for timeouts, security servers report them as
+// NetworkError or ServiceFailed faults, see IsNetworkError and IsServiceFailed.
+func IsTimeout(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var he HTTPError
+	if errors.As(err, &he) {
+		return he.Code == http.StatusGatewayTimeout
+	}
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
@@ -0,0 +1,95 @@
+package xroad
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestFaultCodes(t *testing.T) {
+	tests := []struct {
+		code      string
+		xroadCode string
+		source    FaultSource
+	}{
+		{"Server.ServerProxy.ServiceFailed.InternalError", FaultServiceFailed, FaultFromServerProxy},
+		{"Server.ClientProxy.NetworkError", FaultNetworkError, FaultFromClientProxy},
+		{"SOAP-ENV:Server.ClientProxy.OutdatedGlobalConf", FaultOutdatedGlobalConf, FaultFromClientProxy},
+		{"Client.InvalidRequest", FaultInvalidRequest, FaultFromProvider},
+		// a provider reusing a code of the security server
+		{"Server.InternalError", FaultInternalError, FaultFromProvider},
+		{"Server.ServerProxy", "", FaultFromServerProxy},
+		{"Server", "", FaultFromProvider},
+	}
+	for _, test := range tests {
+		fault := SOAPFault{Code: test.code}
+		if code := fault.XroadCode(); code != test.xroadCode {
+			t.Errorf("%s: expected XroadCode %q, got %q", test.code, test.xroadCode, code)
+		}
+		if source := fault.Source(); source != test.source {
+			t.Errorf("%s: expected source %s, got %s", test.code, test.source, source)
+		}
+		if fault.FromSecurityServer() != (test.source != FaultFromProvider) {
+			t.Errorf("%s: unexpected FromSecurityServer", test.code)
+		}
+	}
+
+	fault := NewXroadFault(FaultServer, FaultServerProxy, FaultServiceFailed, "failed")
+	if fault.Code != "Server.ServerProxy.ServiceFailed" {
+		t.Errorf("unexpected code %s", fault.Code)
+	}
+	for code, want := range map[string]bool{
+		FaultServer:        true,
+		FaultServerProxy:   true,
+		FaultServiceFailed: true,
+		FaultClientProxy:   false,
+		"Service":          false,
+	} {
+		if fault.HasCode(code) != want {
+			t.Errorf("expected HasCode(%s) %v", code, want)
+		}
+	}
+}
+
+func TestFaultPredicates(t *testing.T) {
+	fault := func(code string) error {
+		return fmt.Errorf("wrapped: %w", SOAPFault{Code: code, String: "timed out"})
+	}
+	restError := func(typ string) error {
+		return fmt.Errorf("wrapped: %w", RESTError{Status: 500, Type: typ, Message: "timed out"})
+	}
+	predicates := map[string]func(error) bool{
+		"IsAccessDenied":   IsAccessDenied,
+		"IsServiceFailed":  IsServiceFailed,
+		"IsNetworkError":   IsNetworkError,
+		"IsUnknownService": IsUnknownService,
+		"IsTimeout":        IsTimeout,
+	}
+	tests := []struct {
+		err  error
+		want string // the only predicate that is true
+	}{
+		{fault("Server.ClientProxy.AccessDenied"), "IsAccessDenied"},
+		{fault("Server.ServerProxy.ServiceFailed.InternalError"), "IsServiceFailed"},
+		{fault("Server.ClientProxy.NetworkError"), "IsNetworkError"},
+		{fault("Server.ServerProxy.UnknownService"), "IsUnknownService"},
+		{fault("Client.ClientProxy.UnknownMember"), "IsUnknownService"},
+		{restError("Server.ClientProxy.AccessDenied"), "IsAccessDenied"},
+		{restError("Server.ServerProxy.ServiceFailed"), "IsServiceFailed"},
+		{context.DeadlineExceeded, "IsTimeout"},
+		{HTTPError{Code: http.StatusGatewayTimeout}, "IsTimeout"},
+		// the faultstring does not count
+		{fault("Server.ClientProxy.InternalError"), ""},
+		{HTTPError{Code: http.StatusBadGateway}, ""},
+		{context.Canceled, ""},
+		{nil, ""},
+	}
+	for _, test := range tests {
+		for name, predicate := range predicates {
+			if got := predicate(test.err); got != (name == test.want) {
+				t.Errorf("%v: expected %s to be %v", test.err, name, !got)
+			}
+		}
+	}
+}
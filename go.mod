@@ -5,4 +5,5 @@ go 1.15
 require (
 	github.com/google/uuid v1.1.2
 	github.com/mash/go-accesslog v1.2.0
+	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519
 )
@@ -44,8 +44,12 @@ func ErrorTo500(h HTTPHandler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if err := h.ServeHTTP(w, r); err != nil {
 			var he HTTPError
+			var le LimitError
 			if errors.As(err, &he) {
 				http.Error(w, he.Str, he.Code)
+			} else if errors.As(err, &le) {
+				Log.Info("limit", le)
+				http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
 			} else {
 				Log.Error("error", err)
 				http.Error(w, "Internal Server Error", 500)
@@ -0,0 +1,484 @@
+package xroad
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// Object types of X-Road identifiers, the objectType attribute.
+const (
+	ObjectTypeMember         = "MEMBER"
+	ObjectTypeSubsystem      = "SUBSYSTEM"
+	ObjectTypeService        = "SERVICE"
+	ObjectTypeCentralService = "CENTRALSERVICE"
+	ObjectTypeServer         = "SERVER"
+	ObjectTypeGlobalGroup    = "GLOBALGROUP"
+	ObjectTypeLocalGroup     = "LOCALGROUP"
+)
+
+var objectTypes = map[string]bool{
+	ObjectTypeMember:         true,
+	ObjectTypeSubsystem:      true,
+	ObjectTypeService:        true,
+	ObjectTypeCentralService: true,
+	ObjectTypeServer:         true,
+	ObjectTypeGlobalGroup:    true,
+	ObjectTypeLocalGroup:     true,
+}
+
+// identifierEscaper percent encodes the characters that would split a part of the encoded form of identifiers.
+var identifierEscaper = strings.NewReplacer("%", "%25", "/", "%2F")
+
+// encodeIdentifier returns the encoded form of an identifier, like SUBSYSTEM:EE/GOV/123/sub.
+// Empty parts are left out.
+func encodeIdentifier(objectType string, parts ...string) string {
+	escaped := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part != "" {
+			escaped = append(escaped, identifierEscaper.Replace(part))
+		}
+	}
+	return objectType + ":" + strings.Join(escaped, "/")
+}
+
+// parseIdentifier parses the FQDN form of an identifier, parts separated by '.',
+// and the encoded form, an optional object type prefix like "SUBSYSTEM:" and parts separated by '/'.
+// Parts of the encoded form are percent decoded, so that they can contain '/' as "%2F".
+// The FQDN form has no escaping, like Fqdn.
+func parseIdentifier(s string) (string, []string, error) {
+	objectType := ""
+	if i := strings.Index(s, ":"); i > 0 && objectTypes[s[:i]] {
+		objectType, s = s[:i], s[i+1:]
+	}
+	if objectType == "" && !strings.Contains(s, "/") {
+		parts := strings.Split(s, ".")
+		for _, part := range parts {
+			if part == "" {
+				return "", nil, WrapError(errors.New("empty part in identifier"))
+			}
+		}
+		return objectType, parts, nil
+	}
+	parts := strings.Split(s, "/")
+	for i, part := range parts {
+		unescaped, err := url.PathUnescape(part)
+		if err != nil {
+			return "", nil, WrapError(err)
+		}
+		if unescaped == "" {
+			return "", nil, WrapError(errors.New("empty part in identifier"))
+		}
+		parts[i] = unescaped
+	}
+	return objectType, parts, nil
+}
+
+// jsonString returns the string b holds, if b is a JSON string rather than an object.
+func jsonString(b []byte) (string, bool, error) {
+	if b = bytes.TrimSpace(b); len(b) == 0 || b[0] != '"' {
+		return "", false, nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return "", false, WrapError(err)
+	}
+	return s, true, nil
+}
+
+// XroadClient, XroadService, SecurityServerId, GlobalGroupId and LocalGroupId marshal to text
+// in the encoded form, as used by flags and JSON map keys.
+// In JSON and XML they keep their fields, their JSON and XML methods keep the text from being used there.
+// UnmarshalJSON accepts the text as a JSON string as well.
+
+// MarshalText returns the encoded form, see Encode.
+func (x XroadClient) MarshalText() ([]byte, error) {
+	return []byte(x.Encode()), nil
+}
+
+// UnmarshalText parses text as NewXroadClient does.
+func (x *XroadClient) UnmarshalText(text []byte) error {
+	return WrapError(x.Set(string(text)))
+}
+
+// MarshalJSON writes an object of the fields, rather than the text of MarshalText.
+func (x XroadClient) MarshalJSON() ([]byte, error) {
+	type plain XroadClient
+	b, err := json.Marshal(plain(x))
+	return b, WrapError(err)
+}
+
+// UnmarshalJSON accepts an object of the fields, or a string as accepted by NewXroadClient,
+// so that configs can write "client": "SUBSYSTEM:EE/GOV/123/sub".
+func (x *XroadClient) UnmarshalJSON(b []byte) error {
+	s, ok, err := jsonString(b)
+	if err != nil {
+		return WrapError(err)
+	}
+	if ok {
+		return WrapError(x.Set(s))
+	}
+	type plain XroadClient
+	return WrapError(json.Unmarshal(b, (*plain)(x)))
+}
+
+// Set parses s as NewXroadClient does, so that *XroadClient is a flag.Value.
+func (x *XroadClient) Set(s string) error {
+	c, err := NewXroadClient(s)
+	if err != nil {
+		return WrapError(err)
+	}
+	*x = *c
+	return nil
+}
+
+// jsonService is XroadService as encoded in JSON.
+// It is not a defined type of XroadService, which would get the methods of XroadClient promoted.
+type jsonService struct {
+	ObjectType     string `json:"objectType,omitempty"`
+	XRoadInstance  string `json:"xRoadInstance"`
+	MemberClass    string `json:"memberClass"`
+	MemberCode     string `json:"memberCode"`
+	SubsystemCode  string `json:"subsystemCode"`
+	ServiceCode    string `json:"serviceCode"`
+	ServiceVersion string `json:"serviceVersion"`
+}
+
+func (x XroadService) MarshalText() ([]byte, error) {
+	return []byte(x.Encode()), nil
+}
+
+func (x *XroadService) UnmarshalText(text []byte) error {
+	return WrapError(x.Set(string(text)))
+}
+
+func (x XroadService) MarshalJSON() ([]byte, error) {
+	b, err := json.Marshal(jsonService{
+		ObjectType:     x.ObjectType,
+		XRoadInstance:  x.XRoadInstance,
+		MemberClass:    x.MemberClass,
+		MemberCode:     x.MemberCode,
+		SubsystemCode:  x.SubsystemCode,
+		ServiceCode:    x.ServiceCode,
+		ServiceVersion: x.ServiceVersion,
+	})
+	return b, WrapError(err)
+}
+
+func (x *XroadService) UnmarshalJSON(b []byte) error {
+	s, ok, err := jsonString(b)
+	if err != nil {
+		return WrapError(err)
+	}
+	if ok {
+		return WrapError(x.Set(s))
+	}
+	var v jsonService
+	if err := json.Unmarshal(b, &v); err != nil {
+		return WrapError(err)
+	}
+	*x = XroadService{
+		XroadClient: XroadClient{
+			ObjectType:    v.ObjectType,
+			XRoadInstance: v.XRoadInstance,
+			MemberClass:   v.MemberClass,
+			MemberCode:    v.MemberCode,
+			SubsystemCode: v.SubsystemCode,
+		},
+		ServiceCode:    v.ServiceCode,
+		ServiceVersion: v.ServiceVersion,
+	}
+	return nil
+}
+
+// Set parses s as NewXroadService does, so that *XroadService is a flag.Value.
+func (x *XroadService) Set(s string) error {
+	service, err := NewXroadService(s)
+	if err != nil {
+		return WrapError(err)
+	}
+	*x = *service
+	return nil
+}
+
+// NewXroadMember returns a member-level client identifier, which has no subsystem.
+func NewXroadMember(xroadInstance, memberClass, memberCode string) XroadClient {
+	return XroadClient{
+		ObjectType:    ObjectTypeMember,
+		XRoadInstance: xroadInstance,
+		MemberClass:   memberClass,
+		MemberCode:    memberCode,
+	}
+}
+
+// IsMember reports whether x identifies a member rather than a subsystem.
+func (x XroadClient) IsMember() bool {
+	return x.ObjectType == ObjectTypeMember
+}
+
+// Member returns the identifier of the member x belongs to.
+func (x XroadClient) Member() XroadClient {
+	return NewXroadMember(x.XRoadInstance, x.MemberClass, x.MemberCode)
+}
+
+// SecurityServerId identifies a security server, as in the securityServer header.
+type SecurityServerId struct {
+	XMLName       xml.Name `xml:"http://x-road.eu/xsd/xroad.xsd securityServer" json:"-"`
+	ObjectType    string   `xml:"http://x-road.eu/xsd/identifiers objectType,attr" json:"objectType,omitempty"`
+	XRoadInstance string   `xml:"http://x-road.eu/xsd/identifiers xRoadInstance" json:"xRoadInstance"`
+	MemberClass   string   `xml:"http://x-road.eu/xsd/identifiers memberClass" json:"memberClass"`
+	MemberCode    string   `xml:"http://x-road.eu/xsd/identifiers memberCode" json:"memberCode"`
+	ServerCode    string   `xml:"http://x-road.eu/xsd/identifiers serverCode" json:"serverCode"`
+}
+
+// Create a new SecurityServerId from its FQDN or the encoded form like SERVER:EE/GOV/123/ss1.
+// ex: EE.GOV.12345678.ss1
+func NewSecurityServerId(fqdn string) (*SecurityServerId, error) {
+	objectType, parts, err := parseIdentifier(fqdn)
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	if (objectType != "" && objectType != ObjectTypeServer) || len(parts) != 4 {
+		return nil, WrapError(errors.New("invalid security server fqdn"))
+	}
+	return &SecurityServerId{
+		ObjectType:    ObjectTypeServer,
+		XRoadInstance: parts[0],
+		MemberClass:   parts[1],
+		MemberCode:    parts[2],
+		ServerCode:    parts[3],
+	}, nil
+}
+
+// Owner returns the member owning the security server.
+func (x SecurityServerId) Owner() XroadClient {
+	return NewXroadMember(x.XRoadInstance, x.MemberClass, x.MemberCode)
+}
+
+func (x SecurityServerId) Equal(y SecurityServerId) bool {
+	if x.XRoadInstance == y.XRoadInstance &&
+		x.MemberClass == y.MemberClass &&
+		x.MemberCode == y.MemberCode &&
+		x.ServerCode == y.ServerCode {
+		return true
+	}
+	return false
+}
+
+func (x SecurityServerId) Fqdn() string {
+	return strings.Join([]string{x.XRoadInstance, x.MemberClass, x.MemberCode, x.ServerCode}, ".")
+}
+
+func (x SecurityServerId) Encode() string {
+	return encodeIdentifier(ObjectTypeServer, x.XRoadInstance, x.MemberClass, x.MemberCode, x.ServerCode)
+}
+
+func (x SecurityServerId) String() string {
+	return x.Fqdn()
+}
+
+func (x SecurityServerId) MarshalText() ([]byte, error) {
+	return []byte(x.Encode()), nil
+}
+
+func (x *SecurityServerId) UnmarshalText(text []byte) error {
+	id, err := NewSecurityServerId(string(text))
+	if err != nil {
+		return WrapError(err)
+	}
+	*x = *id
+	return nil
+}
+
+func (x SecurityServerId) MarshalJSON() ([]byte, error) {
+	type plain SecurityServerId
+	b, err := json.Marshal(plain(x))
+	return b, WrapError(err)
+}
+
+func (x *SecurityServerId) UnmarshalJSON(b []byte) error {
+	s, ok, err := jsonString(b)
+	if err != nil {
+		return WrapError(err)
+	}
+	if ok {
+		return WrapError(x.UnmarshalText([]byte(s)))
+	}
+	type plain SecurityServerId
+	return WrapError(json.Unmarshal(b, (*plain)(x)))
+}
+
+// MarshalXML writes the securityServer element of the header.
+func (x SecurityServerId) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start.Name = xml.Name{Space: "http://x-road.eu/xsd/xroad.xsd", Local: "securityServer"}
+	type plain SecurityServerId
+	return WrapError(e.EncodeElement(plain(x), start))
+}
+
+func (x *SecurityServerId) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	type plain SecurityServerId
+	return WrapError(d.DecodeElement((*plain)(x), &start))
+}
+
+// GlobalGroupId identifies a group defined in the global configuration of an X-Road instance.
+type GlobalGroupId struct {
+	ObjectType    string `xml:"http://x-road.eu/xsd/identifiers objectType,attr" json:"objectType,omitempty"`
+	XRoadInstance string `xml:"http://x-road.eu/xsd/identifiers xRoadInstance" json:"xRoadInstance"`
+	GroupCode     string `xml:"http://x-road.eu/xsd/identifiers groupCode" json:"groupCode"`
+}
+
+// Create a new GlobalGroupId from its FQDN or the encoded form like GLOBALGROUP:EE/owners.
+// ex: EE.security-server-owners
+func NewGlobalGroupId(fqdn string) (*GlobalGroupId, error) {
+	objectType, parts, err := parseIdentifier(fqdn)
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	if (objectType != "" && objectType != ObjectTypeGlobalGroup) || len(parts) != 2 {
+		return nil, WrapError(errors.New("invalid global group fqdn"))
+	}
+	return &GlobalGroupId{
+		ObjectType:    ObjectTypeGlobalGroup,
+		XRoadInstance: parts[0],
+		GroupCode:     parts[1],
+	}, nil
+}
+
+func (x GlobalGroupId) Equal(y GlobalGroupId) bool {
+	return x.XRoadInstance == y.XRoadInstance && x.GroupCode == y.GroupCode
+}
+
+func (x GlobalGroupId) Fqdn() string {
+	return x.XRoadInstance + "." + x.GroupCode
+}
+
+func (x GlobalGroupId) Encode() string {
+	return encodeIdentifier(ObjectTypeGlobalGroup, x.XRoadInstance, x.GroupCode)
+}
+
+func (x GlobalGroupId) String() string {
+	return x.Fqdn()
+}
+
+func (x GlobalGroupId) MarshalText() ([]byte, error) {
+	return []byte(x.Encode()), nil
+}
+
+func (x *GlobalGroupId) UnmarshalText(text []byte) error {
+	id, err := NewGlobalGroupId(string(text))
+	if err != nil {
+		return WrapError(err)
+	}
+	*x = *id
+	return nil
+}
+
+func (x GlobalGroupId) MarshalJSON() ([]byte, error) {
+	type plain GlobalGroupId
+	b, err := json.Marshal(plain(x))
+	return b, WrapError(err)
+}
+
+func (x *GlobalGroupId) UnmarshalJSON(b []byte) error {
+	s, ok, err := jsonString(b)
+	if err != nil {
+		return WrapError(err)
+	}
+	if ok {
+		return WrapError(x.UnmarshalText([]byte(s)))
+	}
+	type plain GlobalGroupId
+	return WrapError(json.Unmarshal(b, (*plain)(x)))
+}
+
+func (x GlobalGroupId) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	type plain GlobalGroupId
+	return WrapError(e.EncodeElement(plain(x), start))
+}
+
+func (x *GlobalGroupId) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	type plain GlobalGroupId
+	return WrapError(d.DecodeElement((*plain)(x), &start))
+}
+
+// LocalGroupId identifies a group defined on the security server of the service provider.
+// Local groups have no instance, the group code is the FQDN.
+type LocalGroupId struct {
+	ObjectType string `xml:"http://x-road.eu/xsd/identifiers objectType,attr" json:"objectType,omitempty"`
+	GroupCode  string `xml:"http://x-road.eu/xsd/identifiers groupCode" json:"groupCode"`
+}
+
+// Create a new LocalGroupId from its FQDN, the group code, or the encoded form like LOCALGROUP:admins.
+func NewLocalGroupId(fqdn string) (*LocalGroupId, error) {
+	objectType, parts, err := parseIdentifier(fqdn)
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	if (objectType != "" && objectType != ObjectTypeLocalGroup) || len(parts) != 1 {
+		return nil, WrapError(errors.New("invalid local group fqdn"))
+	}
+	return &LocalGroupId{
+		ObjectType: ObjectTypeLocalGroup,
+		GroupCode:  parts[0],
+	}, nil
+}
+
+func (x LocalGroupId) Equal(y LocalGroupId) bool {
+	return x.GroupCode == y.GroupCode
+}
+
+func (x LocalGroupId) Fqdn() string {
+	return x.GroupCode
+}
+
+func (x LocalGroupId) Encode() string {
+	return encodeIdentifier(ObjectTypeLocalGroup, x.GroupCode)
+}
+
+func (x LocalGroupId) String() string {
+	return x.Fqdn()
+}
+
+func (x LocalGroupId) MarshalText() ([]byte, error) {
+	return []byte(x.Encode()), nil
+}
+
+func (x *LocalGroupId) UnmarshalText(text []byte) error {
+	id, err := NewLocalGroupId(string(text))
+	if err != nil {
+		return WrapError(err)
+	}
+	*x = *id
+	return nil
+}
+
+func (x LocalGroupId) MarshalJSON() ([]byte, error) {
+	type plain LocalGroupId
+	b, err := json.Marshal(plain(x))
+	return b, WrapError(err)
+}
+
+func (x *LocalGroupId) UnmarshalJSON(b []byte) error {
+	s, ok, err := jsonString(b)
+	if err != nil {
+		return WrapError(err)
+	}
+	if ok {
+		return WrapError(x.UnmarshalText([]byte(s)))
+	}
+	type plain LocalGroupId
+	return WrapError(json.Unmarshal(b, (*plain)(x)))
+}
+
+func (x LocalGroupId) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	type plain LocalGroupId
+	return WrapError(e.EncodeElement(plain(x), start))
+}
+
+func (x *LocalGroupId) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	type plain LocalGroupId
+	return WrapError(d.DecodeElement((*plain)(x), &start))
+}
@@ -0,0 +1,267 @@
+package xroad
+
+import (
+	"encoding"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestIdentifiers(t *testing.T) {
+	ss, err := NewSecurityServerId("EE.GOV.123.ss1")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if ss.Fqdn() != "EE.GOV.123.ss1" || !ss.Owner().Equal(NewXroadMember("EE", "GOV", "123")) {
+		t.Errorf("unexpected security server %+v", ss)
+	}
+	if _, err := NewSecurityServerId("EE.GOV.123"); err == nil {
+		t.Errorf("expected an error")
+	}
+	if g, err := NewGlobalGroupId("EE.owners"); err != nil || g.Fqdn() != "EE.owners" {
+		t.Errorf("unexpected global group %v, %v", g, err)
+	}
+	if g, err := NewLocalGroupId("admins"); err != nil || g.Fqdn() != "admins" {
+		t.Errorf("unexpected local group %v, %v", g, err)
+	}
+
+	header := SOAPHeader{
+		Client:         NewXroadMember("EE", "GOV", "123"),
+		SecurityServer: ss,
+	}
+	header.fillDefaults()
+	if header.Client.Fqdn() != "EE.GOV.123" || !header.Client.IsMember() {
+		t.Errorf("unexpected member %s", header.Client)
+	}
+	b, err := xml.Marshal(NewEnvelope(header, &testBody{}))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if strings.Contains(string(b), "subsystemCode") {
+		t.Errorf("member with a subsystemCode: %s", b)
+	}
+	var e SOAPEnvelope
+	e.Body = &testBody{}
+	if err := xml.Unmarshal(b, &e); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if e.Header.SecurityServer == nil || !e.Header.SecurityServer.Equal(*ss) || e.Header.SecurityServer.ObjectType != ObjectTypeServer {
+		t.Errorf("unexpected securityServer %v in %s", e.Header.SecurityServer, b)
+	}
+	if e.Header.Client.ObjectType != ObjectTypeMember {
+		t.Errorf("unexpected client %+v", e.Header.Client)
+	}
+}
+
+func TestClientXML(t *testing.T) {
+	const subsystem = `<client xmlns="http://x-road.eu/xsd/xroad.xsd" xmlns:identifiers="http://x-road.eu/xsd/identifiers" identifiers:objectType="SUBSYSTEM">` +
+		`<xRoadInstance xmlns="http://x-road.eu/xsd/identifiers">EE</xRoadInstance>` +
+		`<memberClass xmlns="http://x-road.eu/xsd/identifiers">GOV</memberClass>` +
+		`<memberCode xmlns="http://x-road.eu/xsd/identifiers">123</memberCode>` +
+		`<subsystemCode xmlns="http://x-road.eu/xsd/identifiers"></subsystemCode></client>`
+	tests := []struct {
+		client XroadClient
+		want   string
+	}{
+		// the subsystemCode element is written even if empty
+		{XroadClient{ObjectType: ObjectTypeSubsystem, XRoadInstance: "EE", MemberClass: "GOV", MemberCode: "123"}, subsystem},
+		// the default object type is SUBSYSTEM
+		{XroadClient{XRoadInstance: "EE", MemberClass: "GOV", MemberCode: "123"}, subsystem},
+		{NewXroadMember("EE", "GOV", "123"), strings.Replace(strings.Replace(subsystem, "SUBSYSTEM", "MEMBER", 1),
+			`<subsystemCode xmlns="http://x-road.eu/xsd/identifiers"></subsystemCode>`, "", 1)},
+	}
+	for _, test := range tests {
+		header := SOAPHeader{Client: test.client}
+		header.fillDefaults()
+		b, err := xml.Marshal(header.Client)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if string(b) != test.want {
+			t.Errorf("%+v: expected\n%s\ngot\n%s", test.client, test.want, b)
+		}
+		var decoded XroadClient
+		if err := xml.Unmarshal(b, &decoded); err != nil {
+			t.Fatalf("%s", err)
+		}
+		if !decoded.Equal(header.Client) || decoded.ObjectType != header.Client.ObjectType {
+			t.Errorf("expected %+v, decoded %+v", header.Client, decoded)
+		}
+	}
+
+	// a service keeps its own element
+	b, err := xml.Marshal(XroadService{XroadClient: testProvider, ServiceCode: "getPerson"})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if !strings.HasPrefix(string(b), `<service xmlns="http://x-road.eu/xsd/xroad.xsd"`) || !strings.Contains(string(b), "<serviceVersion") {
+		t.Errorf("unexpected service %s", b)
+	}
+}
+
+func TestIdentifierCodec(t *testing.T) {
+	tests := []struct {
+		in, fqdn, encoded string
+	}{
+		{"EE.GOV.123.sub", "EE.GOV.123.sub", "SUBSYSTEM:EE/GOV/123/sub"},
+		{"SUBSYSTEM:EE/GOV/123/sub", "EE.GOV.123.sub", "SUBSYSTEM:EE/GOV/123/sub"},
+		{"EE/GOV/123", "EE.GOV.123", "MEMBER:EE/GOV/123"},
+		// only the encoded form is escaped
+		{"MEMBER:EE/GOV/1.2%2F3", "EE.GOV.1.2/3", "MEMBER:EE/GOV/1.2%2F3"},
+		{"EE.GOV.1%2E2.sub", "EE.GOV.1%2E2.sub", "SUBSYSTEM:EE/GOV/1%252E2/sub"},
+	}
+	for _, test := range tests {
+		c, err := NewXroadClient(test.in)
+		if err != nil {
+			t.Errorf("%s: %s", test.in, err)
+			continue
+		}
+		if c.Fqdn() != test.fqdn || c.Encode() != test.encoded {
+			t.Errorf("%s: got %s and %s", test.in, c.Fqdn(), c.Encode())
+		}
+		if c2, err := NewXroadClient(c.Encode()); err != nil || !c2.Equal(*c) {
+			t.Errorf("%s: %s does not round trip: %v %v", test.in, c.Encode(), c2, err)
+		}
+	}
+	for _, in := range []string{"EE.GOV", "EE.GOV.123.sub.x", "SERVICE:EE/GOV/123/sub", "EE..123", "EE/GOV/%zz"} {
+		if _, err := NewXroadClient(in); err == nil {
+			t.Errorf("%s: expected an error", in)
+		}
+	}
+
+	s, err := NewXroadService("SERVICE:EE/GOV/123/sub/getPerson")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if s.ServiceVersion != "" || s.Fqdn() != "EE.GOV.123.sub.getPerson." {
+		t.Errorf("unexpected service %+v", s)
+	}
+	if s2, err := NewXroadService(s.Fqdn()); err != nil || !s2.Equal(*s) {
+		t.Errorf("%s does not round trip: %v %v", s.Fqdn(), s2, err)
+	}
+	if s, err := NewXroadService("EE.GOV.123.sub.getPerson.v1"); err != nil || s.Encode() != "SERVICE:EE/GOV/123/sub/getPerson/v1" {
+		t.Errorf("unexpected service %v, %v", s, err)
+	}
+
+	var header SOAPHeader
+	err = json.Unmarshal([]byte(`{"client":"SUBSYSTEM:EE/GOV/123/sub","service":{"xRoadInstance":"EE","memberClass":"GOV","memberCode":"456","subsystemCode":"p","serviceCode":"get","serviceVersion":"v1"}}`), &header)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if header.Client.Fqdn() != "EE.GOV.123.sub" || header.Service.Fqdn() != "EE.GOV.456.p.get.v1" {
+		t.Errorf("unexpected header %s", header)
+	}
+	if err := json.Unmarshal([]byte(`{"service":"EE/GOV/456/p/get"}`), &header); err != nil || header.Service.ServiceCode != "get" {
+		t.Errorf("unexpected service %v, %v", header.Service, err)
+	}
+
+	// the object type decides, as in IsMember and Fqdn
+	subsystem := XroadClient{ObjectType: ObjectTypeSubsystem, XRoadInstance: "EE", MemberClass: "GOV", MemberCode: "123"}
+	if subsystem.IsMember() || !strings.HasPrefix(subsystem.Encode(), "SUBSYSTEM:") {
+		t.Errorf("unexpected subsystem %s", subsystem.Encode())
+	}
+	member := XroadClient{ObjectType: ObjectTypeMember, XRoadInstance: "EE", MemberClass: "GOV", MemberCode: "123", SubsystemCode: "sub"}
+	if !member.IsMember() || member.Encode() != "MEMBER:EE/GOV/123" || member.Fqdn() != "EE.GOV.123" {
+		t.Errorf("unexpected member %s", member.Encode())
+	}
+
+	var flagValue XroadClient
+	var _ flag.Value = &flagValue
+	if err := flagValue.Set("EE.GOV.123"); err != nil || !flagValue.IsMember() {
+		t.Errorf("unexpected client %v, %v", flagValue, err)
+	}
+}
+
+func TestIdentifierMarshal(t *testing.T) {
+	client, _ := NewXroadClient("SUBSYSTEM:EE/GOV/1.2%2F3/sub")
+	service, _ := NewXroadService("SERVICE:EE/GOV/123/sub/get%2Fperson/v1")
+	server, _ := NewSecurityServerId("SERVER:EE/GOV/123/ss1")
+	global, _ := NewGlobalGroupId("GLOBALGROUP:EE/owners")
+	local, _ := NewLocalGroupId("LOCALGROUP:admins")
+	member := NewXroadMember("EE", "GOV", "123")
+
+	type identifier interface {
+		Encode() string
+		MarshalText() ([]byte, error)
+	}
+	tests := []struct {
+		in  identifier
+		out interface{} // a pointer to a zero value of the type of in
+	}{
+		{client, &XroadClient{}},
+		{&member, &XroadClient{}},
+		{service, &XroadService{}},
+		{server, &SecurityServerId{}},
+		{global, &GlobalGroupId{}},
+		{local, &LocalGroupId{}},
+	}
+	reset := func(v interface{}) interface{} {
+		return reflect.New(reflect.TypeOf(v).Elem()).Interface()
+	}
+	for _, test := range tests {
+		want := reflect.ValueOf(test.in).Elem().Interface()
+		check := func(codec string, got interface{}) {
+			// set by the XML decoder
+			if name := reflect.ValueOf(got).Elem().FieldByName("XMLName"); name.IsValid() {
+				name.Set(reflect.Zero(name.Type()))
+			}
+			if !reflect.DeepEqual(reflect.ValueOf(got).Elem().Interface(), want) {
+				t.Errorf("%s: %s round trip: expected %+v, got %+v", test.in.Encode(), codec, want, got)
+			}
+		}
+
+		text, err := test.in.MarshalText()
+		if err != nil || string(text) != test.in.Encode() {
+			t.Errorf("%s: unexpected text %s, %v", test.in.Encode(), text, err)
+		}
+		out := reset(test.out)
+		if err := out.(encoding.TextUnmarshaler).UnmarshalText(text); err != nil {
+			t.Fatalf("%s: %s", text, err)
+		}
+		check("text", out)
+
+		// JSON keeps the object of the fields, and accepts the text as well
+		b, err := json.Marshal(test.in)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if !strings.HasPrefix(string(b), "{") {
+			t.Errorf("%s: expected a JSON object, got %s", text, b)
+		}
+		for _, b := range [][]byte{b, []byte(strconv.Quote(string(text)))} {
+			out := reset(test.out)
+			if err := json.Unmarshal(b, out); err != nil {
+				t.Fatalf("%s: %s", b, err)
+			}
+			check("JSON "+string(b), out)
+		}
+
+		// XML keeps the elements
+		b, err = xml.Marshal(test.in)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if !strings.Contains(string(b), "</") {
+			t.Errorf("%s: expected XML elements, got %s", text, b)
+		}
+		out = reset(test.out)
+		if err := xml.Unmarshal(b, out); err != nil {
+			t.Fatalf("%s: %s", b, err)
+		}
+		check("XML", out)
+	}
+
+	// the text is used for JSON map keys
+	b, err := json.Marshal(map[XroadClient]int{*client: 1})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	var m map[XroadClient]int
+	if err := json.Unmarshal(b, &m); err != nil || m[*client] != 1 {
+		t.Errorf("unexpected map %s: %v, %v", b, m, err)
+	}
+}
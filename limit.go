@@ -0,0 +1,78 @@
+package xroad
+
+import (
+	"fmt"
+	"io"
+)
+
+// LimitError is returned when a message exceeds one of the limits in DecodeOptions.
+type LimitError struct {
+	Limit string // name of the DecodeOptions field
+	Max   int64
+}
+
+func (e LimitError) Error() string {
+	return fmt.Sprintf("%s of %d exceeded", e.Limit, e.Max)
+}
+
+// limitReader fails with a LimitError when more than max bytes are read from r.
+// max <= 0 means no limit.
+type limitReader struct {
+	r        io.Reader
+	n        int64
+	err      LimitError
+	exceeded bool
+	// outer is a limit on the reader r reads from, like the total size of the message
+	// an attachment is read from. Its LimitError is passed on from Read.
+	outer *limitReader
+}
+
+func newLimitReader(r io.Reader, limit string, max int64) *limitReader {
+	return &limitReader{
+		r: r,
+		n: max,
+		err: LimitError{
+			Limit: limit,
+			Max:   max,
+		},
+	}
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.err.Max <= 0 {
+		n, err := l.r.Read(p)
+		return n, l.outerCause(err)
+	}
+	if l.exceeded {
+		return 0, l.err
+	}
+	// read one byte more than allowed, to tell a message of exactly max bytes from a larger one
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) <= l.n {
+		l.n -= int64(n)
+		return n, l.outerCause(err)
+	}
+	n = int(l.n)
+	l.n = 0
+	l.exceeded = true
+	return n, l.err
+}
+
+// cause returns the LimitError if the limit was exceeded, because decoders
+// like mime/multipart might not pass through the error from the underlying reader.
+func (l *limitReader) cause(err error) error {
+	if l.exceeded {
+		return l.err
+	}
+	return l.outerCause(err)
+}
+
+func (l *limitReader) outerCause(err error) error {
+	if err == nil || err == io.EOF || l.outer == nil {
+		return err
+	}
+	return l.outer.cause(err)
+}
@@ -0,0 +1,285 @@
+package xroad
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+)
+
+// Metadata service codes.
+// https://github.com/nordic-institute/X-Road/blob/develop/doc/Protocols/pr-meta_x-road_service_metadata_protocol.md
+const (
+	ServiceListMethods    = "listMethods"
+	ServiceAllowedMethods = "allowedMethods"
+	ServiceGetWsdl        = "getWsdl"
+)
+
+type listMethodsBody struct {
+	ListMethods struct{} `xml:"http://x-road.eu/xsd/xroad.xsd listMethods"`
+}
+
+type allowedMethodsBody struct {
+	AllowedMethods struct{} `xml:"http://x-road.eu/xsd/xroad.xsd allowedMethods"`
+}
+
+type getWsdlBody struct {
+	GetWsdl getWsdl `xml:"http://x-road.eu/xsd/xroad.xsd getWsdl"`
+}
+
+type getWsdl struct {
+	ServiceCode    string `xml:"serviceCode"`
+	ServiceVersion string `xml:"serviceVersion,omitempty"`
+}
+
+type getWsdlResponseBody struct {
+	GetWsdlResponse getWsdl `xml:"http://x-road.eu/xsd/xroad.xsd getWsdlResponse"`
+}
+
+type methodList struct {
+	Services []XroadService `xml:"http://x-road.eu/xsd/xroad.xsd service"`
+}
+
+type listMethodsResponseBody struct {
+	Response methodList `xml:"http://x-road.eu/xsd/xroad.xsd listMethodsResponse"`
+}
+
+type allowedMethodsResponseBody struct {
+	Response methodList `xml:"http://x-road.eu/xsd/xroad.xsd allowedMethodsResponse"`
+}
+
+// same fields as XroadClient, but the element is named id
+type clientListId struct {
+	XMLName       xml.Name `xml:"http://x-road.eu/xsd/xroad.xsd id"`
+	ObjectType    string   `xml:"http://x-road.eu/xsd/identifiers objectType,attr"`
+	XRoadInstance string   `xml:"http://x-road.eu/xsd/identifiers xRoadInstance"`
+	MemberClass   string   `xml:"http://x-road.eu/xsd/identifiers memberClass"`
+	MemberCode    string   `xml:"http://x-road.eu/xsd/identifiers memberCode"`
+	SubsystemCode string   `xml:"http://x-road.eu/xsd/identifiers subsystemCode"`
+}
+
+type clientList struct {
+	XMLName xml.Name `xml:"http://x-road.eu/xsd/xroad.xsd clientList"`
+	Members []struct {
+		Id   clientListId `xml:""`
+		Name string       `xml:"http://x-road.eu/xsd/xroad.xsd name"`
+	} `xml:"http://x-road.eu/xsd/xroad.xsd member"`
+}
+
+// metadataHeader returns a header for calling the metadata service serviceCode of provider.
+func (c Client) metadataHeader(provider XroadClient, serviceCode string) SOAPHeader {
+	header := c.CloneHeader()
+	header.CentralService = nil
+	provider.ObjectType = ""
+	header.Service = &XroadService{
+		XroadClient: provider,
+		ServiceCode: serviceCode,
+	}
+	return header
+}
+
+func (c Client) sendMetadata(ctx context.Context, header SOAPHeader, body, resBody interface{}) (*http.Response, error) {
+	res, err := c.SendContext(ctx, header, body, &SOAPEnvelope{Body: resBody})
+	if err != nil {
+		if res != nil {
+			res.Body.Close()
+		}
+		return nil, WrapError(err)
+	}
+	return res, nil
+}
+
+// ListMethods lists the services provided by provider.
+func (c Client) ListMethods(ctx context.Context, provider XroadClient) ([]XroadService, error) {
+	var resBody listMethodsResponseBody
+	res, err := c.sendMetadata(ctx, c.metadataHeader(provider, ServiceListMethods), listMethodsBody{}, &resBody)
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	res.Body.Close()
+	return resBody.Response.Services, nil
+}
+
+// AllowedMethods lists the services of provider that our client is allowed to call.
+func (c Client) AllowedMethods(ctx context.Context, provider XroadClient) ([]XroadService, error) {
+	var resBody allowedMethodsResponseBody
+	res, err := c.sendMetadata(ctx, c.metadataHeader(provider, ServiceAllowedMethods), allowedMethodsBody{}, &resBody)
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	res.Body.Close()
+	return resBody.Response.Services, nil
+}
+
+// GetWsdl returns the WSDL describing service.
+func (c Client) GetWsdl(ctx context.Context, service XroadService) ([]byte, error) {
+	body := getWsdlBody{
+		GetWsdl: getWsdl{
+			ServiceCode:    service.ServiceCode,
+			ServiceVersion: service.ServiceVersion,
+		},
+	}
+	var resBody getWsdlResponseBody
+	resEnvelope := SOAPEnvelope{Body: &resBody}
+	res, err := c.SendContext(ctx, c.metadataHeader(service.XroadClient, ServiceGetWsdl), body, &resEnvelope)
+	if res != nil {
+		defer res.Body.Close()
+	}
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	// the WSDL is sent as an attachment
+	if resEnvelope.XOP == nil {
+		return nil, WrapError(errors.New("getWsdl response without attachment"))
+	}
+	defer resEnvelope.XOP.Close()
+	file, err := resEnvelope.XOP.NextFile()
+	if err == io.EOF {
+		return nil, WrapError(errors.New("getWsdl response without attachment"))
+	}
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	b, err := ioutil.ReadAll(file.File)
+	return b, WrapError(err)
+}
+
+// ListClients lists the members and subsystems of xroadInstance known to the security server.
+// Members are returned with an empty SubsystemCode and ObjectType MEMBER.
+// If xroadInstance is empty, the security server's own instance is listed.
+func (c Client) ListClients(ctx context.Context, xroadInstance string) ([]XroadClient, error) {
+	base, err := url.Parse(c.Url)
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	// listClients is served at the root of the security server, whatever the path of c.Url
+	u := &url.URL{Scheme: base.Scheme, Host: base.Host, Path: "/listClients"}
+	if xroadInstance != "" {
+		u.RawQuery = url.Values{"xRoadInstance": []string{xroadInstance}}.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	req.Header.Set("Accept", "text/xml")
+	req.Header.Set("User-Agent", UserAgent)
+
+	res, err := c.Do(req)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, WrapError(ctxErr)
+		}
+		return nil, WrapError(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, WrapError(HTTPError{
+			Code:  res.StatusCode,
+			Str:   res.Status,
+			Cause: fmt.Errorf("listClients failed"),
+		})
+	}
+
+	var list clientList
+	if err := xml.NewDecoder(res.Body).Decode(&list); err != nil {
+		return nil, WrapError(err)
+	}
+	clients := make([]XroadClient, 0, len(list.Members))
+	for _, m := range list.Members {
+		client := XroadClient(m.Id)
+		client.XMLName = xml.Name{}
+		clients = append(clients, client)
+	}
+	return clients, nil
+}
+
+// MethodACL reports whether client is allowed to call the service serviceCode.
+type MethodACL func(client XroadClient, serviceCode string) bool
+
+// Metadata configures the listMethods and allowedMethods services answered by Mux itself.
+type Metadata struct {
+	// Version is reported for services not found in Versions.
+	Version string
+	// Versions maps service codes to service versions.
+	// Versions of operations registered by HandleOperation are used for services not found here.
+	Versions map[string]string
+	// ACL filters the allowedMethods response. All services are allowed if nil.
+	ACL MethodACL
+}
+
+func (m *Mux) version(md Metadata, serviceCode string) string {
+	if v, ok := md.Versions[serviceCode]; ok {
+		return v
+	}
+	if op, ok := m.operations[serviceCode]; ok && op.Version != "" {
+		return op.Version
+	}
+	return md.Version
+}
+
+// HandleMetadata makes the Mux answer listMethods and allowedMethods
+// with the services registered by Handle, HandleFunc and HandleOperation.
+// The "*" fallback handler and metadata services are not listed.
+func (m *Mux) HandleMetadata(md Metadata) {
+	m.HandleFunc(ServiceListMethods, func(w http.ResponseWriter, r *http.Request, e SOAPEnvelope) error {
+		res := e.NewResponseEnvelope(listMethodsResponseBody{
+			Response: methodList{
+				Services: m.services(e.Header.Service.XroadClient, md, nil),
+			},
+		})
+		return WrapError(WriteSoap(http.StatusOK, res, w))
+	})
+	m.HandleFunc(ServiceAllowedMethods, func(w http.ResponseWriter, r *http.Request, e SOAPEnvelope) error {
+		client := e.Header.Client
+		allowed := func(serviceCode string) bool {
+			return md.ACL == nil || md.ACL(client, serviceCode)
+		}
+		res := e.NewResponseEnvelope(allowedMethodsResponseBody{
+			Response: methodList{
+				Services: m.services(e.Header.Service.XroadClient, md, allowed),
+			},
+		})
+		return WrapError(WriteSoap(http.StatusOK, res, w))
+	})
+}
+
+func isMetadataService(serviceCode string) bool {
+	switch serviceCode {
+	case ServiceListMethods, ServiceAllowedMethods, ServiceGetWsdl:
+		return true
+	}
+	return false
+}
+
+// services lists the registered services of provider, sorted by service code.
+func (m *Mux) services(provider XroadClient, md Metadata, filter func(string) bool) []XroadService {
+	codes := make([]string, 0, len(m.handlers))
+	for code := range m.handlers {
+		if code == "*" || isMetadataService(code) {
+			continue
+		}
+		if filter != nil && !filter(code) {
+			continue
+		}
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	provider.XMLName = xml.Name{}
+	provider.ObjectType = "SERVICE"
+	services := make([]XroadService, 0, len(codes))
+	for _, code := range codes {
+		services = append(services, XroadService{
+			XroadClient:    provider,
+			ServiceCode:    code,
+			ServiceVersion: m.version(md, code),
+		})
+	}
+	return services
+}
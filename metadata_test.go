@@ -0,0 +1,197 @@
+package xroad
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const listMethodsResponse = `<SOAP-ENV:Envelope xmlns:SOAP-ENV="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xroad="http://x-road.eu/xsd/xroad.xsd" xmlns:id="http://x-road.eu/xsd/identifiers">
+<SOAP-ENV:Header/>
+<SOAP-ENV:Body>
+<xroad:%sResponse>
+<xroad:service id:objectType="SERVICE">
+<id:xRoadInstance>EE</id:xRoadInstance>
+<id:memberClass>GOV</id:memberClass>
+<id:memberCode>2</id:memberCode>
+<id:subsystemCode>p</id:subsystemCode>
+<id:serviceCode>getPerson</id:serviceCode>
+<id:serviceVersion>v1</id:serviceVersion>
+</xroad:service>
+</xroad:%sResponse>
+</SOAP-ENV:Body>
+</SOAP-ENV:Envelope>`
+
+const listClientsResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<xroad:clientList xmlns:xroad="http://x-road.eu/xsd/xroad.xsd" xmlns:id="http://x-road.eu/xsd/identifiers">
+<xroad:member>
+<xroad:id id:objectType="MEMBER">
+<id:xRoadInstance>EE</id:xRoadInstance>
+<id:memberClass>GOV</id:memberClass>
+<id:memberCode>2</id:memberCode>
+</xroad:id>
+<xroad:name>Provider</xroad:name>
+</xroad:member>
+<xroad:member>
+<xroad:id id:objectType="SUBSYSTEM">
+<id:xRoadInstance>EE</id:xRoadInstance>
+<id:memberClass>GOV</id:memberClass>
+<id:memberCode>2</id:memberCode>
+<id:subsystemCode>p</id:subsystemCode>
+</xroad:id>
+<xroad:name>Provider</xroad:name>
+</xroad:member>
+</xroad:clientList>`
+
+var testProvider = XroadClient{XRoadInstance: "EE", MemberClass: "GOV", MemberCode: "2", SubsystemCode: "p"}
+
+func TestClientMetadata(t *testing.T) {
+	var services []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req getWsdlBody
+		e := SOAPEnvelope{Body: &req}
+		if err := Decode(r, &e); err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+		service := e.Header.Service
+		if service == nil || !service.XroadClient.Equal(testProvider) {
+			t.Errorf("expected the provider in the header, got %v", service)
+			return
+		}
+		services = append(services, service.ServiceCode)
+		switch code := service.ServiceCode; code {
+		case ServiceListMethods, ServiceAllowedMethods:
+			w.Header().Set("Content-Type", "text/xml")
+			w.Write([]byte(fmt.Sprintf(listMethodsResponse, code, code)))
+		case ServiceGetWsdl:
+			if req.GetWsdl.ServiceCode != "getPerson" || req.GetWsdl.ServiceVersion != "v1" {
+				t.Errorf("unexpected getWsdl request %+v", req.GetWsdl)
+			}
+			xop, _ := NewXOP()
+			xop.AddFile("getPerson.wsdl", bytes.NewReader([]byte("<definitions/>")))
+			res := e.NewResponseEnvelope(getWsdlResponseBody{GetWsdlResponse: req.GetWsdl})
+			res.XOP = &xop
+			WriteSoap(http.StatusOK, res, w)
+		}
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, SOAPHeader{
+		Client:         XroadClient{XRoadInstance: "EE", MemberClass: "GOV", MemberCode: "1", SubsystemCode: "c"},
+		CentralService: &XroadCentralService{XRoadInstance: "EE", ServiceCode: "other"},
+	})
+	ctx := context.Background()
+	want := XroadService{XroadClient: testProvider, ServiceCode: "getPerson", ServiceVersion: "v1"}
+	for name, list := range map[string]func(context.Context, XroadClient) ([]XroadService, error){
+		ServiceListMethods:    c.ListMethods,
+		ServiceAllowedMethods: c.AllowedMethods,
+	} {
+		got, err := list(ctx, testProvider)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if len(got) != 1 || !got[0].Equal(want) || got[0].ServiceVersion != "v1" {
+			t.Errorf("%s: unexpected services %v", name, got)
+		}
+	}
+
+	wsdl, err := c.GetWsdl(ctx, want)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if string(wsdl) != "<definitions/>" {
+		t.Errorf("unexpected WSDL %q", wsdl)
+	}
+	if len(services) != 3 {
+		t.Errorf("expected 3 requests, got %v", services)
+	}
+}
+
+func TestClientListClients(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || r.URL.Path != "/listClients" || r.URL.Query().Get("xRoadInstance") != "EE" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(listClientsResponse))
+	}))
+	defer srv.Close()
+
+	// the url of the SOAP endpoint might have a path
+	c := NewClient(srv.URL+"/some/path", SOAPHeader{})
+	clients, err := c.ListClients(context.Background(), "EE")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(clients) != 2 {
+		t.Fatalf("expected 2 clients, got %v", clients)
+	}
+	if clients[0].ObjectType != ObjectTypeMember || clients[0].SubsystemCode != "" || clients[0].MemberCode != "2" {
+		t.Errorf("unexpected member %+v", clients[0])
+	}
+	if clients[1].ObjectType != ObjectTypeSubsystem || !clients[1].Equal(testProvider) {
+		t.Errorf("unexpected subsystem %+v", clients[1])
+	}
+}
+
+func TestMuxMetadata(t *testing.T) {
+	mux := NewMux(testBody{})
+	ok := func(w http.ResponseWriter, r *http.Request, e SOAPEnvelope) error {
+		return WriteSoap(200, e.NewResponseEnvelope(&testBody{Value: "ok"}), w)
+	}
+	mux.HandleFunc("getPerson", ok)
+	mux.HandleOperation(Operation{ServiceCode: "putPerson", Version: "v2"}, SOAPHandlerFunc(ok))
+	mux.HandleFunc("*", ok)
+	mux.HandleMetadata(Metadata{
+		Version: "v1",
+		// only member 1 may put
+		ACL: func(client XroadClient, serviceCode string) bool {
+			return serviceCode != "putPerson" || client.MemberCode == "1"
+		},
+	})
+	srv := httptest.NewServer(ErrorTo500(mux))
+	defer srv.Close()
+
+	codes := func(services []XroadService) string {
+		var ret []string
+		for _, s := range services {
+			if !s.XroadClient.Equal(testProvider) || s.ObjectType != ObjectTypeService {
+				t.Errorf("unexpected provider %v", s)
+			}
+			ret = append(ret, s.ServiceCode+"/"+s.ServiceVersion)
+		}
+		return strings.Join(ret, ",")
+	}
+	ctx := context.Background()
+	for _, test := range []struct {
+		memberCode string
+		allowed    string
+	}{
+		{"1", "getPerson/v1,putPerson/v2"},
+		{"3", "getPerson/v1"},
+	} {
+		c := NewClient(srv.URL, SOAPHeader{
+			Client: XroadClient{XRoadInstance: "EE", MemberClass: "GOV", MemberCode: test.memberCode, SubsystemCode: "c"},
+		})
+		services, err := c.ListMethods(ctx, testProvider)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if got := codes(services); got != "getPerson/v1,putPerson/v2" {
+			t.Errorf("member %s: expected all services listed, got %s", test.memberCode, got)
+		}
+		services, err = c.AllowedMethods(ctx, testProvider)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if got := codes(services); got != test.allowed {
+			t.Errorf("member %s: expected %s allowed, got %s", test.memberCode, test.allowed, got)
+		}
+	}
+}
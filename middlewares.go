@@ -23,6 +23,19 @@ func ErrorToSOAPFault(next SOAPHandler) SOAPHandler {
 				WriteSoap(500, res, w)
 				return nil
 			}
+			var le LimitError
+			if errors.As(err, &le) {
+				// an attachment read in AttachmentsLazy mode was too large
+				Log.Info("limit", le)
+				res := e.NewResponseEnvelope(SOAPFaultBody{
+					Fault: SOAPFault{
+						Code:   "Client",
+						String: le.Error(),
+					},
+				})
+				WriteSoap(500, res, w)
+				return nil
+			}
 			Log.Error("error", WrapError(err))
 			res := e.NewResponseEnvelope(SOAPFaultBody{
 				Fault: SOAPFault{
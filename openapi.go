@@ -0,0 +1,318 @@
+package xroad
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// RESTOperation describes a route of a RESTMux, for OpenAPI generation.
+// Request and Response are values of the types of the JSON bodies, nil if there is no body.
+type RESTOperation struct {
+	ServiceCode string
+	Method      string
+	Path        string // pattern of the route, see RESTMux.Handle
+	Summary     string
+	Request     interface{}
+	Response    interface{}
+}
+
+// HandleOperation registers h for op like Handle,
+// and remembers op to be described in the OpenAPI description.
+func (m *RESTMux) HandleOperation(op RESTOperation, h RESTHandler) {
+	m.Handle(op.ServiceCode, op.Method, op.Path, h)
+	m.operations = append(m.operations, op)
+}
+
+// OpenAPI is an OpenAPI 3 description of a REST service.
+type OpenAPI struct {
+	Title      string
+	Version    string
+	Server     string // url of the provider, optional
+	Operations []RESTOperation
+}
+
+// OpenAPI returns the description of the operations of serviceCode registered by HandleOperation.
+func (m *RESTMux) OpenAPI(serviceCode, title, version string) OpenAPI {
+	o := OpenAPI{
+		Title:   title,
+		Version: version,
+	}
+	for _, op := range m.operations {
+		if op.ServiceCode == serviceCode {
+			o.Operations = append(o.Operations, op)
+		}
+	}
+	return o
+}
+
+// ServeHTTP serves the description, so that the security server can download it by URL.
+func (o OpenAPI) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	b, err := o.Generate()
+	if err != nil {
+		Log.Error("error", WrapError(err))
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Write(b)
+}
+
+// openAPIPath converts a route pattern to an OpenAPI path.
+// A trailing '/' matching any path below is described by the prefix only.
+func openAPIPath(pattern string) (string, []string) {
+	var params []string
+	for _, segment := range strings.Split(pattern, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			params = append(params, segment[1:len(segment)-1])
+		}
+	}
+	path := "/" + strings.Trim(pattern, "/")
+	return path, params
+}
+
+type jsonObject = map[string]interface{}
+
+// Generate writes the description as JSON.
+func (o OpenAPI) Generate() ([]byte, error) {
+	s := &jsonSchemaWriter{
+		types:   make(map[reflect.Type]string),
+		schemas: make(jsonObject),
+	}
+	errorSchema, err := s.schema(reflect.TypeOf(RESTError{}))
+	if err != nil {
+		return nil, WrapError(err)
+	}
+
+	paths := make(jsonObject)
+	for _, op := range o.Operations {
+		path, params := openAPIPath(op.Path)
+		item, ok := paths[path].(jsonObject)
+		if !ok {
+			item = make(jsonObject)
+			paths[path] = item
+		}
+
+		operation := jsonObject{
+			"operationId": operationId(op.Method, path),
+		}
+		if op.Summary != "" {
+			operation["summary"] = op.Summary
+		}
+		if len(params) > 0 {
+			var parameters []jsonObject
+			for _, param := range params {
+				parameters = append(parameters, jsonObject{
+					"name":     param,
+					"in":       "path",
+					"required": true,
+					"schema":   jsonObject{"type": "string"},
+				})
+			}
+			operation["parameters"] = parameters
+		}
+		if op.Request != nil {
+			schema, err := s.schema(reflect.TypeOf(op.Request))
+			if err != nil {
+				return nil, WrapError(fmt.Errorf("%s %s: %w", op.Method, op.Path, err))
+			}
+			operation["requestBody"] = jsonObject{
+				"required": true,
+				"content":  jsonObject{"application/json": jsonObject{"schema": schema}},
+			}
+		}
+		success := jsonObject{"description": "OK"}
+		if op.Response != nil {
+			schema, err := s.schema(reflect.TypeOf(op.Response))
+			if err != nil {
+				return nil, WrapError(fmt.Errorf("%s %s: %w", op.Method, op.Path, err))
+			}
+			success["content"] = jsonObject{"application/json": jsonObject{"schema": schema}}
+		}
+		operation["responses"] = jsonObject{
+			"200": success,
+			"default": jsonObject{
+				"description": "X-Road error",
+				"content":     jsonObject{"application/json": jsonObject{"schema": errorSchema}},
+			},
+		}
+
+		method := strings.ToLower(op.Method)
+		if method == "" {
+			return nil, WrapError(fmt.Errorf("%s: method is required", op.Path))
+		}
+		item[method] = operation
+	}
+
+	doc := jsonObject{
+		"openapi": "3.0.3",
+		"info": jsonObject{
+			"title":   o.Title,
+			"version": o.Version,
+		},
+		"paths":      paths,
+		"components": jsonObject{"schemas": s.schemas},
+	}
+	if o.Server != "" {
+		doc["servers"] = []jsonObject{{"url": o.Server}}
+	}
+	b, err := json.MarshalIndent(doc, "", "  ")
+	return b, WrapError(err)
+}
+
+// operationId is like "getPeopleId" for GET /people/{id}.
+func operationId(method, path string) string {
+	id := strings.ToLower(method)
+	for _, segment := range strings.FieldsFunc(path, func(r rune) bool {
+		return r == '/' || r == '{' || r == '}' || r == '-' || r == '_' || r == '.'
+	}) {
+		id += strings.ToUpper(segment[:1]) + segment[1:]
+	}
+	return id
+}
+
+// jsonSchemaWriter writes OpenAPI schemas for go types, following encoding/json.
+type jsonSchemaWriter struct {
+	types   map[reflect.Type]string // go type to schema name
+	schemas jsonObject
+}
+
+func (s *jsonSchemaWriter) schema(t reflect.Type) (jsonObject, error) {
+	t = indirectType(t)
+	if t == timeType {
+		return jsonObject{"type": "string", "format": "date-time"}, nil
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return jsonObject{"type": "string"}, nil
+	case reflect.Bool:
+		return jsonObject{"type": "boolean"}, nil
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return jsonObject{"type": "integer", "format": "int64"}, nil
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return jsonObject{"type": "integer", "format": "int32"}, nil
+	case reflect.Float32:
+		return jsonObject{"type": "number", "format": "float"}, nil
+	case reflect.Float64:
+		return jsonObject{"type": "number", "format": "double"}, nil
+	case reflect.Interface:
+		return jsonObject{}, nil
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return jsonObject{"type": "string", "format": "byte"}, nil
+		}
+		items, err := s.schema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return jsonObject{"type": "array", "items": items}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("%s: only string map keys are supported", t)
+		}
+		values, err := s.schema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return jsonObject{"type": "object", "additionalProperties": values}, nil
+	case reflect.Struct:
+		return s.structSchema(t)
+	}
+	return nil, fmt.Errorf("%s: unsupported type", t)
+}
+
+// structSchema returns a reference to the schema of t in components, writing it if necessary.
+// Anonymous structs are described inline.
+func (s *jsonSchemaWriter) structSchema(t reflect.Type) (jsonObject, error) {
+	if t.Name() == "" {
+		return s.objectSchema(t)
+	}
+	if name, ok := s.types[t]; ok {
+		return jsonObject{"$ref": "#/components/schemas/" + name}, nil
+	}
+	name := t.Name()
+	for i := 2; s.schemas[name] != nil; i++ {
+		name = fmt.Sprintf("%s%d", strings.TrimRight(name, "0123456789"), i)
+	}
+	s.types[t] = name
+	s.schemas[name] = jsonObject{} // reserve the name, t might refer to itself
+
+	schema, err := s.objectSchema(t)
+	if err != nil {
+		return nil, err
+	}
+	s.schemas[name] = schema
+	return jsonObject{"$ref": "#/components/schemas/" + name}, nil
+}
+
+func (s *jsonSchemaWriter) objectSchema(t reflect.Type) (jsonObject, error) {
+	properties := make(jsonObject)
+	var required []string
+	if err := s.collectProperties(properties, &required, t); err != nil {
+		return nil, err
+	}
+	schema := jsonObject{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema, nil
+}
+
+func (s *jsonSchemaWriter) collectProperties(properties jsonObject, required *[]string, t reflect.Type) error {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue // unexported
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		name := parts[0]
+		if f.Anonymous && name == "" && indirectType(f.Type).Kind() == reflect.Struct {
+			// embedded struct fields are promoted, as encoding/json does
+			if err := s.collectProperties(properties, required, indirectType(f.Type)); err != nil {
+				return err
+			}
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		omitempty, asString := false, false
+		for _, opt := range parts[1:] {
+			switch opt {
+			case "omitempty":
+				omitempty = true
+			case "string":
+				asString = true
+			}
+		}
+
+		var schema jsonObject
+		if asString {
+			schema = jsonObject{"type": "string"}
+		} else {
+			var err error
+			if schema, err = s.schema(f.Type); err != nil {
+				return fmt.Errorf("%s.%s: %w", t, f.Name, err)
+			}
+		}
+		properties[name] = schema
+		if !omitempty && f.Type.Kind() != reflect.Ptr {
+			*required = append(*required, name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package xroad
+
+import (
+	"crypto"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	// register the hash functions of hashAlgorithms
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// Algorithm identifiers of the requestHash header.
+const (
+	HashSHA256 = "http://www.w3.org/2001/04/xmlenc#sha256"
+	HashSHA384 = "http://www.w3.org/2001/04/xmldsig-more#sha384"
+	HashSHA512 = "http://www.w3.org/2001/04/xmlenc#sha512"
+)
+
+var hashAlgorithms = map[string]crypto.Hash{
+	HashSHA256: crypto.SHA256,
+	HashSHA384: crypto.SHA384,
+	HashSHA512: crypto.SHA512,
+}
+
+var (
+	ErrRequestHashMissing  = errors.New("requestHash missing in the response")
+	ErrRequestHashMismatch = errors.New("requestHash does not match the request")
+)
+
+// RequestHash is the requestHash header, which the security server of the provider
+// adds to responses. Value is the base64 encoded hash of the SOAP part of the request.
+type RequestHash struct {
+	AlgorithmId string `xml:"algorithmId,attr"`
+	Value       string `xml:",chardata"`
+}
+
+// NewRequestHash returns the SHA-512 requestHash of soap, the SOAP part of a request as sent.
+func NewRequestHash(soap []byte) RequestHash {
+	h, _ := newRequestHash(HashSHA512, soap)
+	return h
+}
+
+func newRequestHash(algorithmId string, soap []byte) (RequestHash, error) {
+	hash, ok := hashAlgorithms[algorithmId]
+	if !ok {
+		return RequestHash{}, WrapError(fmt.Errorf("unsupported requestHash algorithmId: %s", algorithmId))
+	}
+	h := hash.New()
+	h.Write(soap)
+	return RequestHash{
+		AlgorithmId: algorithmId,
+		Value:       base64.StdEncoding.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// Verify returns ErrRequestHashMismatch if soap, the SOAP part of the request as sent, has a different hash.
+func (r RequestHash) Verify(soap []byte) error {
+	expected, err := newRequestHash(r.AlgorithmId, soap)
+	if err != nil {
+		return WrapError(err)
+	}
+	if subtle.ConstantTimeCompare([]byte(expected.Value), []byte(r.Value)) != 1 {
+		return WrapError(ErrRequestHashMismatch)
+	}
+	return nil
+}
+
+// verifyRequestHash verifies the requestHash of the response header against soap.
+func verifyRequestHash(header SOAPHeader, soap []byte) error {
+	if header.RequestHash == nil {
+		return WrapError(ErrRequestHashMissing)
+	}
+	return WrapError(header.RequestHash.Verify(soap))
+}
@@ -0,0 +1,194 @@
+package xroad
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Headers of the X-Road message protocol for REST.
+// https://github.com/nordic-institute/X-Road/blob/develop/doc/Protocols/pr-rest_x-road_message_protocol_for_rest.md
+const (
+	HeaderClient      = "X-Road-Client"
+	HeaderService     = "X-Road-Service"
+	HeaderId          = "X-Road-Id"
+	HeaderUserId      = "X-Road-UserId"
+	HeaderRequestHash = "X-Road-Request-Hash"
+	HeaderRequestId   = "X-Road-Request-Id"
+	HeaderError       = "X-Road-Error"
+
+	// RESTProtocolVersion is the path prefix of REST requests to the security server.
+	RESTProtocolVersion = "r1"
+)
+
+// RESTClient sends requests to REST services through the security server at Url.
+type RESTClient struct {
+	http.Client
+	Url         string
+	XroadClient XroadClient
+	UserId      string // sent as X-Road-UserId if not empty
+}
+
+func NewRESTClient(url string, client XroadClient) RESTClient {
+	return RESTClient{
+		Client: http.Client{
+			Timeout: 30 * time.Second,
+		},
+		Url:         url,
+		XroadClient: client,
+	}
+}
+
+// restIdentifier joins the parts with '/', percent encoding each part.
+// Empty parts, like the SubsystemCode of a member, are left out.
+func restIdentifier(parts ...string) string {
+	escaped := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part == "" {
+			continue
+		}
+		escaped = append(escaped, url.PathEscape(part))
+	}
+	return strings.Join(escaped, "/")
+}
+
+func restClientId(c XroadClient) string {
+	return restIdentifier(c.XRoadInstance, c.MemberClass, c.MemberCode, c.SubsystemCode)
+}
+
+// restServiceId is the service identifier of REST URLs and the X-Road-Service header.
+// REST services have no version.
+func restServiceId(s XroadService) string {
+	return restIdentifier(s.XRoadInstance, s.MemberClass, s.MemberCode, s.SubsystemCode, s.ServiceCode)
+}
+
+// RESTURL returns the URL of path of service, relative to the security server at base.
+// path can include a query.
+func RESTURL(base string, service XroadService, path string) string {
+	if path != "" && !strings.HasPrefix(path, "/") && !strings.HasPrefix(path, "?") {
+		path = "/" + path
+	}
+	return fmt.Sprintf("%s/%s/%s%s", strings.TrimSuffix(base, "/"), RESTProtocolVersion, restServiceId(service), path)
+}
+
+func (c RESTClient) NewRequest(method string, service XroadService, path string, body io.Reader) (*http.Request, error) {
+	req, err := c.NewRequestWithContext(context.Background(), method, service, path, body)
+	return req, WrapError(err)
+}
+
+// NewRequestWithContext creates a request of method to path of service,
+// with the X-Road-Client and X-Road-UserId headers set. Set Content-Type when sending a body.
+func (c RESTClient) NewRequestWithContext(ctx context.Context, method string, service XroadService, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, RESTURL(c.Url, service, path), body)
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	req.Header.Set(HeaderClient, restClientId(c.XroadClient))
+	if c.UserId != "" {
+		req.Header.Set(HeaderUserId, c.UserId)
+	}
+	req.Header.Set("User-Agent", UserAgent)
+	return req, nil
+}
+
+// RESTResponse is a response of a REST service, with accessors for the headers
+// the security server adds.
+type RESTResponse struct {
+	*http.Response
+}
+
+// Id is the unique id of the message, given by the client's security server.
+func (r RESTResponse) Id() string {
+	return r.Header.Get(HeaderId)
+}
+
+// RequestHash is the hash of the request, as seen by the provider's security server.
+func (r RESTResponse) RequestHash() string {
+	return r.Header.Get(HeaderRequestHash)
+}
+
+// RESTError is the error JSON returned by security servers and providers.
+// Type is a fault code like "Server.ServerProxy.NetworkError",
+// which IsFaultCode and the other fault predicates look at.
+type RESTError struct {
+	Status  int    `json:"-"`
+	Type    string `json:"type"`
+	Message string `json:"message"`
+	Detail  string `json:"detail,omitempty"`
+}
+
+func (e RESTError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Status, e.Type, e.Message)
+}
+
+// Send sends req and returns a RESTError if the response is an error.
+// The body of an error response is read and closed.
+func (c RESTClient) Send(req *http.Request) (*RESTResponse, error) {
+	res, err := c.Do(req)
+	if err != nil {
+		// prefer the context error over the *url.Error wrapping it, see Client.doAndDecode
+		if ctxErr := req.Context().Err(); ctxErr != nil {
+			return nil, WrapError(ctxErr)
+		}
+		return nil, WrapError(err)
+	}
+	if res.StatusCode < 400 && res.Header.Get(HeaderError) == "" {
+		return &RESTResponse{res}, nil
+	}
+
+	defer res.Body.Close()
+	restErr := RESTError{
+		Status: res.StatusCode,
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return &RESTResponse{res}, WrapError(err)
+	}
+	if err := json.Unmarshal(b, &restErr); err != nil || restErr.Type == "" {
+		// not the X-Road error JSON, like an error page of a proxy
+		restErr.Type = res.Header.Get(HeaderError)
+		restErr.Message = res.Status
+		restErr.Detail = string(b)
+	}
+	return &RESTResponse{res}, WrapError(restErr)
+}
+
+// SendJSON sends reqBody as JSON, if not nil, to path of service,
+// and decodes the JSON response into resBody, if not nil. The response body is closed.
+func (c RESTClient) SendJSON(ctx context.Context, method string, service XroadService, path string, reqBody, resBody interface{}) (*RESTResponse, error) {
+	var body io.Reader
+	if reqBody != nil {
+		b, err := json.Marshal(reqBody)
+		if err != nil {
+			return nil, WrapError(err)
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := c.NewRequestWithContext(ctx, method, service, path, body)
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	if reqBody != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := c.Send(req)
+	if err != nil {
+		return res, WrapError(err)
+	}
+	defer res.Body.Close()
+	if resBody != nil {
+		if err := json.NewDecoder(res.Body).Decode(resBody); err != nil {
+			return res, WrapError(err)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,156 @@
+package xroad
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRESTClient(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(HeaderClient) != "EE/GOV/123/sub%2Fsystem" {
+			t.Errorf("unexpected %s: %s", HeaderClient, r.Header.Get(HeaderClient))
+		}
+		if r.Header.Get(HeaderUserId) != "EE123" {
+			t.Errorf("unexpected %s: %s", HeaderUserId, r.Header.Get(HeaderUserId))
+		}
+		w.Header().Set(HeaderId, "id1")
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.RequestURI() {
+		case "/r1/EE/GOV/456/provider/people/1?full=true":
+			w.Write([]byte(`{"name":"Taro"}`))
+		case "/r1/EE/GOV/456/provider/down/1":
+			w.Header().Set(HeaderError, "Server.ServerProxy.NetworkError")
+			w.WriteHeader(500)
+			w.Write([]byte(`{"type":"Server.ServerProxy.NetworkError","message":"connection refused","detail":"e1"}`))
+		default:
+			t.Errorf("unexpected URI %s", r.URL.RequestURI())
+		}
+	}))
+	defer ts.Close()
+
+	c := NewRESTClient(ts.URL, XroadClient{XRoadInstance: "EE", MemberClass: "GOV", MemberCode: "123", SubsystemCode: "sub/system"})
+	c.UserId = "EE123"
+	service := XroadService{
+		XroadClient: XroadClient{XRoadInstance: "EE", MemberClass: "GOV", MemberCode: "456", SubsystemCode: "provider"},
+		ServiceCode: "people",
+	}
+
+	var person struct {
+		Name string `json:"name"`
+	}
+	res, err := c.SendJSON(context.Background(), "GET", service, "/1?full=true", nil, &person)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if person.Name != "Taro" || res.Id() != "id1" {
+		t.Errorf("unexpected response %+v, id %s", person, res.Id())
+	}
+
+	service.ServiceCode = "down"
+	_, err = c.SendJSON(context.Background(), "GET", service, "1", nil, nil)
+	if !IsNetworkError(err) {
+		t.Errorf("expected a network error, got %v", err)
+	}
+}
+
+func TestRESTMux(t *testing.T) {
+	mux := NewRESTMux()
+	mux.HandleFunc("people", "GET", "/people/{id}", func(w http.ResponseWriter, r *http.Request, h RESTHeader) error {
+		if h.Client.SubsystemCode != "" || h.Client.ObjectType != "MEMBER" {
+			t.Errorf("expected a member client, got %+v", h.Client)
+		}
+		w.Write([]byte(PathParam(r, "id")))
+		return nil
+	})
+	mux.HandleFunc("people", "", "/people/", func(w http.ResponseWriter, r *http.Request, h RESTHeader) error {
+		return RESTError{Status: http.StatusConflict, Type: "Client.Conflict", Message: "conflict"}
+	})
+	handler := ErrorTo500(mux)
+
+	do := func(method, path, service string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set(HeaderClient, "EE/GOV/123")
+		if service != "" {
+			req.Header.Set(HeaderService, service)
+		}
+		req.Header.Set(HeaderId, "id1")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("GET", "/people/42", "EE/GOV/456/provider/people")
+	if w.Code != 200 || w.Body.String() != "42" {
+		t.Errorf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get(HeaderId) != "id1" || w.Header().Get(HeaderService) != "EE/GOV/456/provider/people" {
+		t.Errorf("headers not echoed: %v", w.Header())
+	}
+
+	tests := []struct {
+		method, path, service string
+		status                int
+		typ                   string
+	}{
+		{"POST", "/people/42", "EE/GOV/456/provider/people", http.StatusConflict, "Client.Conflict"},
+		{"GET", "/places/1", "EE/GOV/456/provider/people", http.StatusNotFound, ErrRESTNotFound.Type},
+		{"GET", "/people/1", "EE/GOV/456/provider/places", http.StatusNotFound, ErrRESTNotFound.Type},
+		{"GET", "/people/1", "", http.StatusBadRequest, ErrRESTInvalidRequest.Type},
+	}
+	for _, test := range tests {
+		w := do(test.method, test.path, test.service)
+		if w.Code != test.status || w.Header().Get(HeaderError) != test.typ {
+			t.Errorf("%s %s: unexpected response %d %s", test.method, test.path, w.Code, w.Body.String())
+		}
+	}
+}
+
+type testPerson struct {
+	Name     string      `json:"name"`
+	Nickname string      `json:"nickname,omitempty"`
+	Age      int         `json:"age"`
+	Parent   *testPerson `json:"parent"`
+	Tags     []string    `json:"tags,omitempty"`
+}
+
+func TestOpenAPI(t *testing.T) {
+	mux := NewRESTMux()
+	noop := RESTHandlerFunc(func(w http.ResponseWriter, r *http.Request, h RESTHeader) error { return nil })
+	mux.HandleOperation(RESTOperation{ServiceCode: "people", Method: "GET", Path: "/people/{id}", Summary: "Get a person", Response: testPerson{}}, noop)
+	mux.HandleOperation(RESTOperation{ServiceCode: "people", Method: "POST", Path: "/people", Request: &testPerson{}, Response: []testPerson{}}, noop)
+	mux.HandleOperation(RESTOperation{ServiceCode: "places", Method: "GET", Path: "/places"}, noop)
+
+	b, err := mux.OpenAPI("people", "People", "1.0").Generate()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	var doc struct {
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]json.RawMessage `json:"properties"`
+				Required   []string                   `json:"required"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(doc.Paths) != 2 || doc.Paths["/people/{id}"]["get"] == nil || doc.Paths["/people"]["post"] == nil {
+		t.Errorf("unexpected paths %s", b)
+	}
+	person := doc.Components.Schemas["testPerson"]
+	if len(person.Properties) != 5 {
+		t.Errorf("unexpected properties %s", b)
+	}
+	if strings.Join(person.Required, ",") != "age,name" {
+		t.Errorf("unexpected required %v", person.Required)
+	}
+	if _, ok := doc.Components.Schemas["RESTError"]; !ok {
+		t.Errorf("RESTError schema missing")
+	}
+}
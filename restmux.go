@@ -0,0 +1,325 @@
+package xroad
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strings"
+)
+
+// RESTHeader holds the X-Road headers of a REST request, as sent by the provider's security server.
+type RESTHeader struct {
+	Client  XroadClient
+	Service XroadService
+	Id      string
+	UserId  string
+}
+
+func (h RESTHeader) String() string {
+	return fmt.Sprintf("id: %s, userId: %s, service: [%s], client: [%s]", h.Id, h.UserId, h.Service, h.Client)
+}
+
+// parseRESTIdentifier splits a '/' separated identifier of the X-Road-Client and X-Road-Service headers,
+// and percent decodes the parts.
+func parseRESTIdentifier(s string) ([]string, error) {
+	if !strings.Contains(s, "/") {
+		return nil, WrapError(fmt.Errorf("invalid identifier: %s", s))
+	}
+	_, parts, err := parseIdentifier(s)
+	return parts, WrapError(err)
+}
+
+// ParseRESTHeader reads the X-Road headers of a REST request.
+// The X-Road-Client of a member has no subsystem code.
+func ParseRESTHeader(h http.Header) (RESTHeader, error) {
+	var ret RESTHeader
+	client := h.Get(HeaderClient)
+	if client == "" {
+		return ret, WrapError(fmt.Errorf("%s missing", HeaderClient))
+	}
+	parts, err := parseRESTIdentifier(client)
+	if err != nil {
+		return ret, WrapError(err)
+	}
+	switch len(parts) {
+	case 3:
+		ret.Client = XroadClient{ObjectType: ObjectTypeMember, XRoadInstance: parts[0], MemberClass: parts[1], MemberCode: parts[2]}
+	case 4:
+		ret.Client = XroadClient{ObjectType: ObjectTypeSubsystem, XRoadInstance: parts[0], MemberClass: parts[1], MemberCode: parts[2], SubsystemCode: parts[3]}
+	default:
+		return ret, WrapError(fmt.Errorf("invalid %s: %s", HeaderClient, client))
+	}
+
+	service := h.Get(HeaderService)
+	if service == "" {
+		return ret, WrapError(fmt.Errorf("%s missing", HeaderService))
+	}
+	parts, err = parseRESTIdentifier(service)
+	if err != nil {
+		return ret, WrapError(err)
+	}
+	if len(parts) != 5 {
+		return ret, WrapError(fmt.Errorf("invalid %s: %s", HeaderService, service))
+	}
+	ret.Service = XroadService{
+		XroadClient: XroadClient{ObjectType: ObjectTypeService, XRoadInstance: parts[0], MemberClass: parts[1], MemberCode: parts[2], SubsystemCode: parts[3]},
+		ServiceCode: parts[4],
+	}
+
+	ret.Id = h.Get(HeaderId)
+	ret.UserId = h.Get(HeaderUserId)
+	return ret, nil
+}
+
+type RESTHandler interface {
+	ServeREST(http.ResponseWriter, *http.Request, RESTHeader) error
+}
+
+type RESTHandlerFunc func(http.ResponseWriter, *http.Request, RESTHeader) error
+
+func (f RESTHandlerFunc) ServeREST(w http.ResponseWriter, r *http.Request, h RESTHeader) error {
+	return f(w, r, h)
+}
+
+type RESTMiddleware func(RESTHandler) RESTHandler
+
+type restRoute struct {
+	method  string // empty matches any method
+	pattern string
+	handler RESTHandler
+}
+
+// RESTMux routes REST requests by the service code of X-Road-Service, the method and the path.
+// Use it through ErrorTo500, like Mux.
+type RESTMux struct {
+	routes      map[string][]restRoute
+	operations  []RESTOperation
+	Middlewares []RESTMiddleware
+}
+
+func NewRESTMux() *RESTMux {
+	return &RESTMux{
+		routes: make(map[string][]restRoute),
+		Middlewares: []RESTMiddleware{
+			ErrorToRESTError,
+			RESTHeaderLog(Log),
+			RecoverREST,
+		},
+	}
+}
+
+// Handle registers h for method requests of serviceCode matching pattern.
+// serviceCode "*" is the fallback for any service and method "" matches any method.
+// In pattern, a "{name}" segment matches any segment, which PathParam returns,
+// and a trailing '/' matches any path below it.
+func (m *RESTMux) Handle(serviceCode, method, pattern string, h RESTHandler) {
+	m.routes[serviceCode] = append(m.routes[serviceCode], restRoute{
+		method:  method,
+		pattern: pattern,
+		handler: h,
+	})
+}
+
+func (m *RESTMux) HandleFunc(serviceCode, method, pattern string, h func(http.ResponseWriter, *http.Request, RESTHeader) error) {
+	m.Handle(serviceCode, method, pattern, RESTHandlerFunc(h))
+}
+
+type pathParamsKey struct{}
+
+// PathParam returns the path segment matching "{name}" in the pattern of the route.
+func PathParam(r *http.Request, name string) string {
+	params, _ := r.Context().Value(pathParamsKey{}).(map[string]string)
+	return params[name]
+}
+
+// matchPath matches path against pattern and returns the path parameters,
+// and the number of literal segments, so that the most specific route wins.
+func matchPath(pattern, path string) (map[string]string, int, bool) {
+	prefix := strings.HasSuffix(pattern, "/")
+	ps := strings.Split(strings.Trim(pattern, "/"), "/")
+	ss := strings.Split(strings.Trim(path, "/"), "/")
+	if ps[0] == "" {
+		ps = nil
+	}
+	if ss[0] == "" {
+		ss = nil
+	}
+	if len(ss) < len(ps) || (!prefix && len(ss) != len(ps)) {
+		return nil, 0, false
+	}
+	params := make(map[string]string)
+	literals := 0
+	for i, p := range ps {
+		if strings.HasPrefix(p, "{") && strings.HasSuffix(p, "}") {
+			params[p[1:len(p)-1]] = ss[i]
+			continue
+		}
+		if p != ss[i] {
+			return nil, 0, false
+		}
+		literals++
+	}
+	return params, literals, true
+}
+
+func (m *RESTMux) serveREST2(w http.ResponseWriter, r *http.Request, h RESTHeader) error {
+	routes, ok := m.routes[h.Service.ServiceCode]
+	if !ok {
+		// fallback to "*"
+		routes = m.routes["*"]
+	}
+	var found *restRoute
+	var params map[string]string
+	best := -1
+	methodMismatch := false
+	for i, route := range routes {
+		p, literals, ok := matchPath(route.pattern, r.URL.Path)
+		if !ok || literals <= best {
+			continue
+		}
+		if route.method != "" && route.method != r.Method {
+			methodMismatch = true
+			continue
+		}
+		found, params, best = &routes[i], p, literals
+	}
+	if found == nil {
+		if methodMismatch {
+			return ErrRESTMethodNotAllowed
+		}
+		return ErrRESTNotFound
+	}
+	r = r.WithContext(context.WithValue(r.Context(), pathParamsKey{}, params))
+	return WrapError(found.handler.ServeREST(w, r, h))
+}
+
+func (m *RESTMux) serveREST(w http.ResponseWriter, r *http.Request, h RESTHeader) error {
+	var next RESTHandler
+	next = RESTHandlerFunc(m.serveREST2)
+	for _, middleware := range m.Middlewares {
+		next = middleware(next)
+	}
+	return WrapError(next.ServeREST(w, r, h))
+}
+
+func (m *RESTMux) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	h, err := ParseRESTHeader(r.Header)
+	if err != nil {
+		ret := ErrRESTInvalidRequest
+		ret.Message = err.Error()
+		WriteRESTError(w, ret)
+		return nil
+	}
+	// echo the headers of the request, which the security server expects in the response
+	w.Header().Set(HeaderClient, r.Header.Get(HeaderClient))
+	w.Header().Set(HeaderService, r.Header.Get(HeaderService))
+	if h.Id != "" {
+		w.Header().Set(HeaderId, h.Id)
+	}
+	return WrapError(m.serveREST(w, r, h))
+}
+
+var (
+	ErrRESTInvalidRequest = RESTError{
+		Status:  http.StatusBadRequest,
+		Type:    FaultClient + "." + FaultInvalidRequest,
+		Message: "Invalid request",
+	}
+	ErrRESTNotFound = RESTError{
+		Status:  http.StatusNotFound,
+		Type:    FaultClient + ".NotFound",
+		Message: "Not found",
+	}
+	ErrRESTMethodNotAllowed = RESTError{
+		Status:  http.StatusMethodNotAllowed,
+		Type:    FaultClient + ".MethodNotAllowed",
+		Message: "Method not allowed",
+	}
+)
+
+// WriteRESTError writes e as the X-Road REST error JSON, with status 500 if e.Status is not set.
+func WriteRESTError(w http.ResponseWriter, e RESTError) error {
+	if e.Status == 0 {
+		e.Status = http.StatusInternalServerError
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(HeaderError, e.Type)
+	w.WriteHeader(e.Status)
+	return WrapError(json.NewEncoder(w).Encode(e))
+}
+
+// ErrorToRESTError writes errors as X-Road REST errors.
+// RESTErrors are written as is, SOAPFaults are converted, and other errors become internal errors.
+func ErrorToRESTError(next RESTHandler) RESTHandler {
+	return RESTHandlerFunc(func(w http.ResponseWriter, r *http.Request, h RESTHeader) error {
+		err := next.ServeREST(w, r, h)
+		if err == nil {
+			return nil
+		}
+		var restErr RESTError
+		if errors.As(err, &restErr) {
+			Log.Info("error", restErr)
+			WriteRESTError(w, restErr)
+			return nil
+		}
+		var fault SOAPFault
+		if errors.As(err, &fault) {
+			Log.Info("fault", fault)
+			WriteRESTError(w, RESTError{
+				Type:    fault.Code,
+				Message: fault.String,
+			})
+			return nil
+		}
+		var le LimitError
+		if errors.As(err, &le) {
+			Log.Info("limit", le)
+			WriteRESTError(w, RESTError{
+				Status:  http.StatusRequestEntityTooLarge,
+				Type:    FaultClient + "." + FaultInvalidRequest,
+				Message: le.Error(),
+			})
+			return nil
+		}
+		Log.Error("error", WrapError(err))
+		WriteRESTError(w, RESTError{
+			Type:    FaultServer + "." + FaultInternalError,
+			Message: "Internal Server Error",
+		})
+		return nil
+	})
+}
+
+func RESTHeaderLog(l Logger) func(RESTHandler) RESTHandler {
+	return func(next RESTHandler) RESTHandler {
+		return RESTHandlerFunc(func(w http.ResponseWriter, r *http.Request, h RESTHeader) error {
+			l.Info("header", h, "method", r.Method, "path", r.URL.Path)
+			return WrapError(next.ServeREST(w, r, h))
+		})
+	}
+}
+
+func RecoverREST(next RESTHandler) RESTHandler {
+	return RESTHandlerFunc(func(w http.ResponseWriter, r *http.Request, h RESTHeader) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				switch x := r.(type) {
+				case string:
+					err = errors.New(x)
+				case error:
+					err = x
+				default:
+					err = errors.New("panic")
+				}
+			}
+			if err != nil {
+				stack := debug.Stack()
+				Log.Error("error", err, "stack", string(stack))
+			}
+		}()
+		return next.ServeREST(w, r, h)
+	})
+}
@@ -0,0 +1,103 @@
+package xroad
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy decides whether a failed Client request should be sent again.
+type RetryPolicy interface {
+	// Backoff is called after the attempt'th attempt failed with err, counting from 1.
+	// It returns how long to wait before the next attempt, or false to give up.
+	Backoff(attempt int, err error) (time.Duration, bool)
+}
+
+// ExponentialBackoff retries with exponentially growing delays.
+// The delay before the n'th retry is Initial * Multiplier^(n-1), capped at Max,
+// and randomized by +-Jitter of itself.
+type ExponentialBackoff struct {
+	MaxAttempts int // including the first attempt
+	Initial     time.Duration
+	Max         time.Duration
+	Multiplier  float64
+	Jitter      float64 // 0 to 1
+	// Retryable classifies errors, IsRetryable is used when nil.
+	Retryable func(error) bool
+}
+
+func NewExponentialBackoff(maxAttempts int) *ExponentialBackoff {
+	return &ExponentialBackoff{
+		MaxAttempts: maxAttempts,
+		Initial:     200 * time.Millisecond,
+		Max:         10 * time.Second,
+		Multiplier:  2,
+		Jitter:      0.2,
+	}
+}
+
+func (b *ExponentialBackoff) Backoff(attempt int, err error) (time.Duration, bool) {
+	if attempt >= b.MaxAttempts {
+		return 0, false
+	}
+	retryable := b.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+	if !retryable(err) {
+		return 0, false
+	}
+
+	wait := float64(b.Initial) * math.Pow(b.Multiplier, float64(attempt-1))
+	if b.Max > 0 && wait > float64(b.Max) {
+		wait = float64(b.Max)
+	}
+	if b.Jitter > 0 {
+		wait += wait * b.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(wait), true
+}
+
+// IsRetryable reports whether err looks like a transient failure,
+// after which the same request might succeed:
+// connection errors of the transport, HTTP 502/503/504 and X-Road network error faults.
+// Context cancellation is never retryable.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if fault, ok := asFault(err); ok {
+		return fault.HasCode(FaultNetworkError)
+	}
+	var he HTTPError
+	if errors.As(err, &he) {
+		switch he.Code {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	// only errors of the transport, not of decoding a response which the server has sent
+	var ue *url.Error
+	var oe *net.OpError
+	if (errors.As(err, &ue) || errors.As(err, &oe)) &&
+		(errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+			errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)) {
+		return true
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	return false
+}
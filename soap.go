@@ -23,8 +23,15 @@ type SOAPHandler interface {
 
 type Mux struct {
 	handlers    map[string]SOAPHandler
+	operations  map[string]Operation
 	Middlewares []SOAPMiddleware
-	body        interface{}
+	// RequestHash makes the Mux compute the requestHash of requests, which responses created by
+	// SOAPEnvelope.NewResponseEnvelope carry, as the security server does.
+	RequestHash bool
+	// DecodeOptions selects how request attachments are read.
+	// Unless they are read into memory, handlers can't read the request.Body again.
+	DecodeOptions DecodeOptions
+	body          interface{}
 }
 
 func VerboseMiddlewares() []SOAPMiddleware {
@@ -37,7 +44,8 @@ func VerboseMiddlewares() []SOAPMiddleware {
 
 func NewMux(body interface{}) *Mux {
 	return &Mux{
-		handlers: make(map[string]SOAPHandler),
+		handlers:   make(map[string]SOAPHandler),
+		operations: make(map[string]Operation),
 		Middlewares: []SOAPMiddleware{
 			ErrorToSOAPFault,
 			SOAPHeaderLog(Log),
@@ -83,28 +91,85 @@ func (m *Mux) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
 
 	var e SOAPEnvelope
 	e.Body = m.NewBody()
-	if err := Decode(r, &e); err != nil {
+	opts := m.DecodeOptions
+	opts.keepRaw = m.RequestHash
+	err := DecodeWithOptions(r, &e, opts)
+	if e.XOP != nil {
+		defer e.XOP.Close()
+	}
+	var le LimitError
+	if errors.As(err, &le) {
+		return WrapError(err)
+	}
+	if err != nil {
 		ret := ErrInvalidXml
 		ret.Cause = err
 		return WrapError(ret)
 	}
+	if m.RequestHash {
+		hash := NewRequestHash(e.raw)
+		e.Header.RequestHash = &hash
+	}
 	return WrapError(m.serveSoap(w, r, e))
 }
 
+// AttachmentMode selects how attachments are read when decoding a XOP message.
+type AttachmentMode int
+
+const (
+	// AttachmentsInMemory reads attachments into memory while decoding.
+	AttachmentsInMemory AttachmentMode = iota
+	// AttachmentsLazy leaves attachments unread, they are read from the message by XOP.NextFile.
+	AttachmentsLazy
+	// AttachmentsSpooled copies attachments to temporary files while decoding.
+	// XOP.Close removes them.
+	AttachmentsSpooled
+)
+
+type DecodeOptions struct {
+	Attachments AttachmentMode
+	// SpoolDir is the directory for AttachmentsSpooled, os.TempDir() if empty.
+	SpoolDir string
+
+	// Limits in bytes, or in number of attachments for MaxAttachments. Zero means no limit.
+	// Exceeding a limit fails decoding, or reading the attachment in AttachmentsLazy mode, with a LimitError.
+	MaxEnvelopeSize   int64 // the SOAP message, without attachments
+	MaxAttachmentSize int64 // each attachment, as sent
+	MaxAttachments    int64
+	MaxTotalSize      int64 // the whole HTTP body
+
+	keepRaw bool // keep the SOAP part as received in SOAPEnvelope.raw, for the requestHash
+}
+
+// streaming reports whether the message should be decoded without reading it into memory first.
+func (o DecodeOptions) streaming() bool {
+	return o.Attachments != AttachmentsInMemory
+}
+
 // Decode parses the request.Body to xroad.SOAPEnvelope or to xroad.XOP
 // depending on the Content-Type request header.
 // After the body is read, we seek to the start of the request.Body
 // future consumers.
 func Decode(r *http.Request, envelope *SOAPEnvelope) error {
+	return WrapError(DecodeWithOptions(r, envelope, DecodeOptions{}))
+}
+
+// DecodeWithOptions is like Decode, but reads attachments as opts tells.
+// Unless attachments are read into memory, the request.Body is consumed by the decoder
+// and can't be read again.
+func DecodeWithOptions(r *http.Request, envelope *SOAPEnvelope, opts DecodeOptions) error {
 	contentType := r.Header.Get("Content-Type")
+	if opts.streaming() {
+		return WrapError(DecodeReaderWithOptions(r.Body, contentType, envelope, opts))
+	}
 
-	b, err := ioutil.ReadAll(r.Body)
+	b, err := ioutil.ReadAll(newLimitReader(r.Body, "MaxTotalSize", opts.MaxTotalSize))
 	if err != nil {
 		return WrapError(err)
 	}
 	body := bytes.NewReader(b)
 
-	if err := DecodeReader(body, contentType, envelope); err != nil {
+	if err := DecodeReaderWithOptions(body, contentType, envelope, opts); err != nil {
 		return WrapError(err)
 	}
 	if _, err := body.Seek(0, io.SeekStart); err != nil {
@@ -114,24 +179,97 @@ func Decode(r *http.Request, envelope *SOAPEnvelope) error {
 	return nil
 }
 
+// DecodeResponse parses the response.Body into envelope.
+// If the SOAP Body contains a Fault, which security servers and providers return
+// with HTTP status 500, the Fault is returned as a SOAPFault error
+// and envelope.Body is left untouched. Only envelope.Header is filled in that case.
 func DecodeResponse(r *http.Response, envelope *SOAPEnvelope) error {
+	return WrapError(DecodeResponseWithOptions(r, envelope, DecodeOptions{}))
+}
+
+// DecodeResponseWithOptions is like DecodeResponse, but reads attachments as opts tells.
+func DecodeResponseWithOptions(r *http.Response, envelope *SOAPEnvelope, opts DecodeOptions) error {
 	if r == nil {
 		return errors.New("invalid response")
 	}
-	return WrapError(DecodeReader(r.Body, r.Header.Get("Content-Type"), envelope))
+	contentType := r.Header.Get("Content-Type")
+	if strings.HasPrefix(contentType, "multipart/") {
+		// a Fault might come with attachments too
+		xop, err := newXOPFromReader(contentType, r.Body, envelope, opts, true)
+		if err != nil {
+			return WrapError(err)
+		}
+		envelope.XOP = xop
+		return nil
+	}
+	if !strings.HasPrefix(contentType, "text/xml") {
+		return WrapError(DecodeReaderWithOptions(r.Body, contentType, envelope, opts))
+	}
+
+	total := newLimitReader(r.Body, "MaxTotalSize", opts.MaxTotalSize)
+	b, err := ioutil.ReadAll(newLimitReader(total, "MaxEnvelopeSize", opts.MaxEnvelopeSize))
+	if err != nil {
+		return WrapError(err)
+	}
+	if err := decodeFault(b, envelope); err != nil {
+		return WrapError(err)
+	}
+	return WrapError(DecodeReaderWithOptions(bytes.NewReader(b), contentType, envelope, opts))
+}
+
+// faultEnvelope is used to look for a Fault in the SOAP Body
+// without touching the caller's body type.
+type faultEnvelope struct {
+	XMLName xml.Name   `xml:"http://schemas.xmlsoap.org/soap/envelope/ Envelope"`
+	Header  SOAPHeader `xml:""`
+	Body    struct {
+		Fault *SOAPFault `xml:"http://schemas.xmlsoap.org/soap/envelope/ Fault"`
+	} `xml:"http://schemas.xmlsoap.org/soap/envelope/ Body"`
+}
+
+// decodeFault returns the SOAPFault found in b, or nil.
+// Parse errors are ignored here and left for the caller's decoder to report.
+func decodeFault(b []byte, envelope *SOAPEnvelope) error {
+	var fe faultEnvelope
+	if err := xml.Unmarshal(b, &fe); err != nil {
+		return nil
+	}
+	if fe.Body.Fault == nil {
+		return nil
+	}
+	envelope.Header = fe.Header
+	return *fe.Body.Fault
 }
 
 func DecodeReader(r io.Reader, contentType string, envelope *SOAPEnvelope) error {
+	return WrapError(DecodeReaderWithOptions(r, contentType, envelope, DecodeOptions{}))
+}
+
+func DecodeReaderWithOptions(r io.Reader, contentType string, envelope *SOAPEnvelope, opts DecodeOptions) error {
 	if strings.HasPrefix(contentType, "text/xml") {
 		// parse SOAP
-		dec := xml.NewDecoder(r)
-		if err := dec.Decode(envelope); err != nil {
+		// without attachments the whole message is the envelope
+		total := newLimitReader(r, "MaxTotalSize", opts.MaxTotalSize)
+		lr := newLimitReader(total, "MaxEnvelopeSize", opts.MaxEnvelopeSize)
+		if !opts.keepRaw {
+			dec := xml.NewDecoder(lr)
+			if err := dec.Decode(envelope); err != nil {
+				return WrapError(total.cause(lr.cause(err)))
+			}
+			return nil
+		}
+		b, err := ioutil.ReadAll(lr)
+		if err != nil {
+			return WrapError(total.cause(lr.cause(err)))
+		}
+		if err := xml.Unmarshal(b, envelope); err != nil {
 			return WrapError(err)
 		}
+		envelope.raw = b
 		return nil
 	} else if strings.HasPrefix(contentType, "multipart/") {
 		// parse multipart
-		xop, err := NewXOPFromReader(contentType, r, envelope)
+		xop, err := NewXOPFromReaderWithOptions(contentType, r, envelope, opts)
 		if err != nil {
 			return WrapError(err)
 		}
@@ -0,0 +1,220 @@
+package xroad
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+const faultResponse = `<?xml version="1.0" encoding="UTF-8"?>
+<SOAP-ENV:Envelope xmlns:SOAP-ENV="http://schemas.xmlsoap.org/soap/envelope/" xmlns:xroad="http://x-road.eu/xsd/xroad.xsd">
+<SOAP-ENV:Header><xroad:id>ID</xroad:id></SOAP-ENV:Header>
+<SOAP-ENV:Body>
+<SOAP-ENV:Fault>
+<faultcode>Server.ClientProxy.NetworkError</faultcode>
+<faultstring>Connection refused</faultstring>
+</SOAP-ENV:Fault>
+</SOAP-ENV:Body>
+</SOAP-ENV:Envelope>`
+
+type testBody struct {
+	Value string `xml:"http://example.com value"`
+}
+
+func TestDecodeResponseFault(t *testing.T) {
+	res := &http.Response{
+		StatusCode: http.StatusInternalServerError,
+		Header:     http.Header{"Content-Type": []string{"text/xml; charset=utf-8"}},
+		Body:       ioutil.NopCloser(strings.NewReader(faultResponse)),
+	}
+	body := testBody{Value: "untouched"}
+	e := SOAPEnvelope{Body: &body}
+
+	err := DecodeResponse(res, &e)
+	var fault SOAPFault
+	if !errors.As(err, &fault) {
+		t.Fatalf("expected SOAPFault, got %v", err)
+	}
+	if fault.Code != "Server.ClientProxy.NetworkError" || fault.String != "Connection refused" {
+		t.Errorf("unexpected fault: %s", fault)
+	}
+	if e.Header.Id != "ID" {
+		t.Errorf("expected header id to be decoded, got %q", e.Header.Id)
+	}
+	if body.Value != "untouched" {
+		t.Errorf("expected body untouched, got %q", body.Value)
+	}
+}
+
+func TestDecodeResponse(t *testing.T) {
+	res := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"text/xml"}},
+		Body: ioutil.NopCloser(strings.NewReader(`<Envelope xmlns="http://schemas.xmlsoap.org/soap/envelope/">` +
+			`<Body><value xmlns="http://example.com">ok</value></Body></Envelope>`)),
+	}
+	var body testBody
+	e := SOAPEnvelope{Body: &body}
+	if err := DecodeResponse(res, &e); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if body.Value != "ok" {
+		t.Errorf("expected ok, got %q", body.Value)
+	}
+}
+
+func TestDecodeResponseMultipartFault(t *testing.T) {
+	for _, format := range []XOPFormat{XOPMTOM, XOPSwA} {
+		xop, err := NewXOP()
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		xop.Format = format
+		if _, err := xop.AddFile("a.txt", strings.NewReader("attachment")); err != nil {
+			t.Fatalf("%s", err)
+		}
+		xop.SOAPEnvelope = NewEnvelope(SOAPHeader{Id: "ID"}, SOAPFaultBody{
+			Fault: NewXroadFault(FaultServer, FaultServerProxy, FaultServiceFailed, "failed"),
+		})
+		var b bytes.Buffer
+		if _, err := xop.WriteTo(&b); err != nil {
+			t.Fatalf("%s", err)
+		}
+
+		res := &http.Response{
+			StatusCode: http.StatusInternalServerError,
+			Header:     http.Header{"Content-Type": []string{xop.ContentType()}},
+			Body:       ioutil.NopCloser(&b),
+		}
+		body := testBody{Value: "untouched"}
+		e := SOAPEnvelope{Body: &body}
+		err = DecodeResponse(res, &e)
+		var fault SOAPFault
+		if !errors.As(err, &fault) || fault.Code != "Server.ServerProxy.ServiceFailed" {
+			t.Errorf("%s: expected the SOAPFault, got %v", format, err)
+		}
+		if e.Header.Id != "ID" || body.Value != "untouched" {
+			t.Errorf("%s: expected the header only, got %q %q", format, e.Header.Id, body.Value)
+		}
+	}
+}
+
+func TestMuxLimits(t *testing.T) {
+	var served int32
+	mux := NewMux(testBody{})
+	mux.DecodeOptions = DecodeOptions{MaxEnvelopeSize: 4000}
+	mux.HandleFunc("*", func(w http.ResponseWriter, r *http.Request, e SOAPEnvelope) error {
+		atomic.AddInt32(&served, 1)
+		return WriteSoap(200, e.NewResponseEnvelope(&testBody{Value: "ok"}), w)
+	})
+	srv := httptest.NewServer(ErrorTo500(mux))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, SOAPHeader{Service: &XroadService{ServiceCode: "echo"}})
+	if _, err := c.Send(c.CloneHeader(), &testBody{Value: "small"}, &SOAPEnvelope{Body: &testBody{}}); err != nil {
+		t.Fatalf("%s", err)
+	}
+	_, err := c.Send(c.CloneHeader(), &testBody{Value: strings.Repeat("x", 4000)}, &SOAPEnvelope{Body: &testBody{}})
+	var he HTTPError
+	if !errors.As(err, &he) || he.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413, got %v", err)
+	}
+	if n := atomic.LoadInt32(&served); n != 1 {
+		t.Errorf("expected the handler to be called once, got %d", n)
+	}
+}
+
+func TestMuxLimitsLazy(t *testing.T) {
+	// the attachment is read by the handler, after decoding
+	mux := NewMux(testFileBody{})
+	mux.DecodeOptions = DecodeOptions{Attachments: AttachmentsLazy, MaxAttachmentSize: 10}
+	mux.Middlewares = []SOAPMiddleware{ErrorToSOAPFault}
+	mux.HandleFunc("*", func(w http.ResponseWriter, r *http.Request, e SOAPEnvelope) error {
+		file, err := e.XOP.NextFile()
+		if err != nil {
+			return WrapError(err)
+		}
+		if _, err := ioutil.ReadAll(file.File); err != nil {
+			return WrapError(err)
+		}
+		return WriteSoap(200, e.NewResponseEnvelope(&testBody{Value: "ok"}), w)
+	})
+	srv := httptest.NewServer(ErrorTo500(mux))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, SOAPHeader{Service: &XroadService{ServiceCode: "upload"}})
+	send := func(attachment string) error {
+		_, err := c.SendXOP(c.CloneHeader(), &testFileBody{}, strings.NewReader(attachment), "a.txt", &SOAPEnvelope{Body: &testBody{}})
+		return err
+	}
+	if err := send("small"); err != nil {
+		t.Fatalf("%s", err)
+	}
+	err := send(strings.Repeat("x", 100))
+	var fault SOAPFault
+	if !errors.As(err, &fault) || fault.Code != "Client" || !strings.Contains(fault.String, "MaxAttachmentSize") {
+		t.Errorf("expected a Client fault, got %v", err)
+	}
+}
+
+func TestClientLimits(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e SOAPEnvelope
+		e.Body = &testBody{}
+		if err := Decode(r, &e); err != nil {
+			t.Errorf("%s", err)
+			return
+		}
+		WriteSoap(200, e.NewResponseEnvelope(&testBody{Value: strings.Repeat("x", 1000)}), w)
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, SOAPHeader{Service: &XroadService{ServiceCode: "echo"}})
+	c.DecodeOptions = DecodeOptions{MaxTotalSize: 1000}
+	_, err := c.Send(c.CloneHeader(), &testBody{}, &SOAPEnvelope{Body: &testBody{}})
+	var le LimitError
+	if !errors.As(err, &le) || le.Limit != "MaxTotalSize" {
+		t.Errorf("expected a LimitError, got %v", err)
+	}
+
+	c.DecodeOptions.MaxTotalSize = 10000
+	if _, err := c.Send(c.CloneHeader(), &testBody{}, &SOAPEnvelope{Body: &testBody{}}); err != nil {
+		t.Errorf("%s", err)
+	}
+}
+
+func TestDecodeKeepRaw(t *testing.T) {
+	b, err := xml.Marshal(NewEnvelope(SOAPHeader{Id: "ID"}, &testBody{Value: "raw"}))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	x, err := NewXOP()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	x.AddFile("a.txt", strings.NewReader("attachment"))
+	x.SOAPEnvelope = NewEnvelope(SOAPHeader{Id: "ID"}, &testFileBody{})
+	var multipart bytes.Buffer
+	if _, err := x.WriteTo(&multipart); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	for _, keepRaw := range []bool{false, true} {
+		for contentType, body := range map[string][]byte{"text/xml": b, x.ContentType(): multipart.Bytes()} {
+			e := SOAPEnvelope{Body: &testFileBody{}}
+			if err := DecodeReaderWithOptions(bytes.NewReader(body), contentType, &e, DecodeOptions{keepRaw: keepRaw}); err != nil {
+				t.Fatalf("%s", err)
+			}
+			// only the Mux computing the requestHash needs the raw SOAP part
+			if keepRaw != (len(e.raw) > 0) || (keepRaw && !bytes.Contains(e.raw, []byte("<id"))) {
+				t.Errorf("%s: keepRaw %v, got %q", contentType, keepRaw, e.raw)
+			}
+		}
+	}
+}
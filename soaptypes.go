@@ -13,6 +13,7 @@ type SOAPEnvelope struct {
 	Header  SOAPHeader  `xml:""`
 	Body    interface{} `xml:"http://schemas.xmlsoap.org/soap/envelope/ Body"`
 	XOP     *XOP        `xml:"-"`
+	raw     []byte      // the SOAP part as received, for the requestHash
 }
 
 func NewEnvelope(h SOAPHeader, b interface{}) SOAPEnvelope {
@@ -29,6 +30,14 @@ func (x SOAPEnvelope) NewResponseEnvelope(body interface{}) SOAPEnvelope {
 	return res
 }
 
+// RepresentedParty returns the party the client represents, if the request has the representedParty header.
+func (x SOAPEnvelope) RepresentedParty() (RepresentedParty, bool) {
+	if x.Header.RepresentedParty == nil {
+		return RepresentedParty{}, false
+	}
+	return *x.Header.RepresentedParty, true
+}
+
 func (x SOAPEnvelope) String() string {
 	return fmt.Sprintf("header: [%s], body: [%s]", x.Header, x.Body)
 }
@@ -45,6 +54,11 @@ type SOAPHeader struct {
 	Service         *XroadService        `xml:"service" json:"service" mapstructure:"service"`
 	CentralService  *XroadCentralService `xml:"centralService" json:"centralService" mapstructure:"centralService"`
 	Client          XroadClient          `xml:"client" json:"client" mapstructure:"client"`
+	// SecurityServer is set when the client wants the request to be served by a specific security server.
+	SecurityServer *SecurityServerId `xml:"http://x-road.eu/xsd/xroad.xsd securityServer,omitempty" json:"securityServer,omitempty" mapstructure:"securityServer"`
+	RequestHash    *RequestHash      `xml:"http://x-road.eu/xsd/xroad.xsd requestHash,omitempty" json:"-"`
+	// RepresentedParty is set when the client sends the request on behalf of another party.
+	RepresentedParty *RepresentedParty `xml:"http://x-road.eu/xsd/representation.xsd representedParty,omitempty" json:"representedParty,omitempty" mapstructure:"representedParty"`
 }
 
 func (x SOAPHeader) String() string {
@@ -58,13 +72,17 @@ func (h *SOAPHeader) fillDefaults() {
 		h.ProtocolVersion = "4.0"
 	}
 	if service := h.Service; service != nil && service.ObjectType == "" {
-		service.ObjectType = "SERVICE"
+		service.ObjectType = ObjectTypeService
 	}
 	if centralService := h.CentralService; centralService != nil && centralService.ObjectType == "" {
-		centralService.ObjectType = "CENTRALSERVICE"
+		centralService.ObjectType = ObjectTypeCentralService
 	}
+	// members send requests with ObjectType MEMBER, see NewXroadMember
 	if h.Client.ObjectType == "" {
-		h.Client.ObjectType = "SUBSYSTEM"
+		h.Client.ObjectType = ObjectTypeSubsystem
+	}
+	if securityServer := h.SecurityServer; securityServer != nil && securityServer.ObjectType == "" {
+		securityServer.ObjectType = ObjectTypeServer
 	}
 }
 
@@ -75,24 +93,61 @@ type XroadService struct {
 	ServiceVersion string   `xml:"http://x-road.eu/xsd/identifiers serviceVersion" json:"serviceVersion"`
 }
 
-// Create a new XroadService from service FQDN.
-// Reading code like FiVRKSignCertificateProfileInfo.java , we assume all the parts don't include a '/'
+// Create a new XroadService from service FQDN, or from the encoded form like SERVICE:EE/GOV/123/sub/getPerson/v1.
+// The service version is optional. See parseIdentifier about escaping.
 func NewXroadService(fqdn string) (*XroadService, error) {
-	parts := strings.Split(fqdn, ".")
-	if len(parts) != 6 {
+	// the Fqdn of a service without a version ends with '.'
+	if !strings.Contains(fqdn, "/") {
+		fqdn = strings.TrimSuffix(fqdn, ".")
+	}
+	objectType, parts, err := parseIdentifier(fqdn)
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	if (objectType != "" && objectType != ObjectTypeService) || (len(parts) != 5 && len(parts) != 6) {
 		return nil, WrapError(errors.New("invalid service fqdn"))
 	}
-	return &XroadService{
+	ret := &XroadService{
 		XroadClient: XroadClient{
-			ObjectType:    "",
+			ObjectType:    objectType,
 			XRoadInstance: parts[0],
 			MemberClass:   parts[1],
 			MemberCode:    parts[2],
 			SubsystemCode: parts[3],
 		},
-		ServiceCode:    parts[4],
-		ServiceVersion: parts[5],
-	}, nil
+		ServiceCode: parts[4],
+	}
+	if len(parts) == 6 {
+		ret.ServiceVersion = parts[5]
+	}
+	return ret, nil
+}
+
+// xmlService is XroadService as encoded in XML.
+type xmlService struct {
+	xmlClient
+	ServiceCode    string `xml:"http://x-road.eu/xsd/identifiers serviceCode"`
+	ServiceVersion string `xml:"http://x-road.eu/xsd/identifiers serviceVersion"`
+}
+
+// MarshalXML is needed as XroadService would otherwise be written by the MarshalXML of XroadClient.
+func (x XroadService) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start.Name = xml.Name{Space: "http://x-road.eu/xsd/xroad.xsd", Local: "service"}
+	return WrapError(e.EncodeElement(xmlService{x.XroadClient.xml(), x.ServiceCode, x.ServiceVersion}, start))
+}
+
+// UnmarshalXML decodes the elements, rather than the text as UnmarshalText would.
+func (x *XroadService) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var v xmlService
+	if err := d.DecodeElement(&v, &start); err != nil {
+		return WrapError(err)
+	}
+	*x = XroadService{
+		XroadClient:    v.client(),
+		ServiceCode:    v.ServiceCode,
+		ServiceVersion: v.ServiceVersion,
+	}
+	return nil
 }
 
 func (x XroadService) Equal(y XroadService) bool {
@@ -108,10 +163,30 @@ func (x XroadService) Fqdn() string {
 	return fmt.Sprintf("%s.%s.%s", x.XroadClient.Fqdn(), x.ServiceCode, x.ServiceVersion)
 }
 
+// Encode returns the encoded form of the identifier, like SERVICE:EE/GOV/123/sub/getPerson/v1.
+func (x XroadService) Encode() string {
+	return encodeIdentifier(ObjectTypeService, x.XRoadInstance, x.MemberClass, x.MemberCode, x.SubsystemCode, x.ServiceCode, x.ServiceVersion)
+}
+
 func (x XroadService) String() string {
 	return x.Fqdn()
 }
 
+// RepresentedParty is the header of the X-Road represented party extension.
+// PartyClass is empty when the party is not a member class, like a citizen.
+// https://github.com/nordic-institute/X-Road/blob/develop/doc/Protocols/pr-third_party_representation_extension.md
+type RepresentedParty struct {
+	PartyClass string `xml:"http://x-road.eu/xsd/representation.xsd partyClass,omitempty" json:"partyClass,omitempty"`
+	PartyCode  string `xml:"http://x-road.eu/xsd/representation.xsd partyCode" json:"partyCode"`
+}
+
+func (x RepresentedParty) String() string {
+	if x.PartyClass == "" {
+		return x.PartyCode
+	}
+	return fmt.Sprintf("%s/%s", x.PartyClass, x.PartyCode)
+}
+
 type XroadCentralService struct {
 	XMLName       xml.Name `xml:"http://x-road.eu/xsd/xroad.xsd centralService" json:"-"`
 	ObjectType    string   `xml:"http://x-road.eu/xsd/identifiers objectType,attr" json:"objectType,omitempty"`
@@ -125,24 +200,82 @@ type XroadClient struct {
 	XRoadInstance string   `xml:"http://x-road.eu/xsd/identifiers xRoadInstance" json:"xRoadInstance"`
 	MemberClass   string   `xml:"http://x-road.eu/xsd/identifiers memberClass" json:"memberClass"`
 	MemberCode    string   `xml:"http://x-road.eu/xsd/identifiers memberCode" json:"memberCode"`
-	SubsystemCode string   `xml:"http://x-road.eu/xsd/identifiers subsystemCode" json:"subsystemCode"`
+	SubsystemCode string   `xml:"http://x-road.eu/xsd/identifiers subsystemCode" json:"subsystemCode"` // empty for members
+}
+
+// xmlClient is XroadClient as encoded in XML, see MarshalXML.
+type xmlClient struct {
+	ObjectType    string  `xml:"http://x-road.eu/xsd/identifiers objectType,attr"`
+	XRoadInstance string  `xml:"http://x-road.eu/xsd/identifiers xRoadInstance"`
+	MemberClass   string  `xml:"http://x-road.eu/xsd/identifiers memberClass"`
+	MemberCode    string  `xml:"http://x-road.eu/xsd/identifiers memberCode"`
+	SubsystemCode *string `xml:"http://x-road.eu/xsd/identifiers subsystemCode"` // nil for members
+}
+
+func (x XroadClient) xml() xmlClient {
+	ret := xmlClient{
+		ObjectType:    x.ObjectType,
+		XRoadInstance: x.XRoadInstance,
+		MemberClass:   x.MemberClass,
+		MemberCode:    x.MemberCode,
+	}
+	if x.ObjectType != ObjectTypeMember {
+		ret.SubsystemCode = &x.SubsystemCode
+	}
+	return ret
+}
+
+// MarshalXML writes the subsystemCode element, even if empty, unless x is a MEMBER.
+func (x XroadClient) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start.Name = xml.Name{Space: "http://x-road.eu/xsd/xroad.xsd", Local: "client"}
+	return WrapError(e.EncodeElement(x.xml(), start))
+}
+
+func (v xmlClient) client() XroadClient {
+	ret := XroadClient{
+		ObjectType:    v.ObjectType,
+		XRoadInstance: v.XRoadInstance,
+		MemberClass:   v.MemberClass,
+		MemberCode:    v.MemberCode,
+	}
+	if v.SubsystemCode != nil {
+		ret.SubsystemCode = *v.SubsystemCode
+	}
+	return ret
 }
 
-// Create a new XroadClient from subsystem FQDN.
-// Reading code like FiVRKSignCertificateProfileInfo.java , we assume all the parts don't include a '.'
+// UnmarshalXML decodes the elements, rather than the text as UnmarshalText would.
+func (x *XroadClient) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var v xmlClient
+	if err := d.DecodeElement(&v, &start); err != nil {
+		return WrapError(err)
+	}
+	*x = v.client()
+	return nil
+}
+
+// Create a new XroadClient from subsystem or member FQDN,
+// or from the encoded form like SUBSYSTEM:EE/GOV/123/sub. See parseIdentifier about escaping.
 // ex: JP-TEST.COM.12973914.librarian
 func NewXroadClient(fqdn string) (*XroadClient, error) {
-	parts := strings.Split(fqdn, ".")
-	if len(parts) != 4 {
-		return nil, WrapError(errors.New("invalid client fqdn"))
+	objectType, parts, err := parseIdentifier(fqdn)
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	switch {
+	case len(parts) == 3 && (objectType == "" || objectType == ObjectTypeMember):
+		ret := NewXroadMember(parts[0], parts[1], parts[2])
+		return &ret, nil
+	case len(parts) == 4 && (objectType == "" || objectType == ObjectTypeSubsystem):
+		return &XroadClient{
+			ObjectType:    objectType,
+			XRoadInstance: parts[0],
+			MemberClass:   parts[1],
+			MemberCode:    parts[2],
+			SubsystemCode: parts[3],
+		}, nil
 	}
-	return &XroadClient{
-		ObjectType:    "",
-		XRoadInstance: parts[0],
-		MemberClass:   parts[1],
-		MemberCode:    parts[2],
-		SubsystemCode: parts[3],
-	}, nil
+	return nil, WrapError(errors.New("invalid client fqdn"))
 }
 
 func (x XroadClient) SameMember(y XroadClient) bool {
@@ -164,10 +297,22 @@ func (x XroadClient) Equal(y XroadClient) bool {
 	return false
 }
 
+// Fqdn joins the parts with '.', which are not escaped. Use Encode for an identifier that can be parsed back.
 func (x XroadClient) Fqdn() string {
+	if x.IsMember() {
+		return fmt.Sprintf("%s.%s.%s", x.XRoadInstance, x.MemberClass, x.MemberCode)
+	}
 	return fmt.Sprintf("%s.%s.%s.%s", x.XRoadInstance, x.MemberClass, x.MemberCode, x.SubsystemCode)
 }
 
+// Encode returns the encoded form of the identifier, like SUBSYSTEM:EE/GOV/123/sub or MEMBER:EE/GOV/123.
+func (x XroadClient) Encode() string {
+	if x.IsMember() {
+		return encodeIdentifier(ObjectTypeMember, x.XRoadInstance, x.MemberClass, x.MemberCode)
+	}
+	return encodeIdentifier(ObjectTypeSubsystem, x.XRoadInstance, x.MemberClass, x.MemberCode, x.SubsystemCode)
+}
+
 func (x XroadClient) String() string {
 	return x.Fqdn()
 }
@@ -224,3 +369,24 @@ type XOPInclude struct {
 	XMLName xml.Name `xml:"http://www.w3.org/2004/08/xop/include Include"`
 	Href    string   `xml:"href,attr"`
 }
+
+// XOPElement is a base64Binary element whose content is sent as a XOP attachment.
+type XOPElement struct {
+	Include *XOPInclude `xml:""`
+}
+
+func NewXOPElement(cid string) *XOPElement {
+	return &XOPElement{
+		Include: &XOPInclude{
+			Href: "cid:" + cid,
+		},
+	}
+}
+
+// ContentId returns the Content-ID of the attachment without the "cid:" prefix.
+func (x XOPElement) ContentId() string {
+	if x.Include == nil {
+		return ""
+	}
+	return strings.TrimPrefix(x.Include.Href, "cid:")
+}
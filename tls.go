@@ -0,0 +1,253 @@
+package xroad
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/pkcs12"
+)
+
+// TLSConfig configures HTTPS between the information system and the security server.
+// The certificate is given either as CertFile and KeyFile in PEM, or as PKCS12File.
+// The certificate files are read again when they change, so they can be renewed without restarts.
+//
+// PKCS12File must use the legacy encryption (3DES or RC2 with SHA-1), as PBES2 with AES is not supported.
+// Newer files can be converted with openssl pkcs12 -legacy.
+type TLSConfig struct {
+	CertFile       string `json:"certFile" mapstructure:"certFile"`
+	KeyFile        string `json:"keyFile" mapstructure:"keyFile"`
+	PKCS12File     string `json:"pkcs12File" mapstructure:"pkcs12File"`
+	PKCS12Password string `json:"pkcs12Password" mapstructure:"pkcs12Password"`
+	// CAFile is a PEM bundle of the trusted certificates of the other party,
+	// usually the internal TLS certificate of the security server.
+	// Clients use the system roots if it is empty.
+	// Unlike the certificate, it is only read when the tls.Config is created.
+	CAFile     string `json:"caFile" mapstructure:"caFile"`
+	ServerName string `json:"serverName" mapstructure:"serverName"` // overrides the host of the url
+	MinVersion string `json:"minVersion" mapstructure:"minVersion"` // "1.2" or "1.3", "1.2" if empty
+}
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func (c TLSConfig) hasCertificate() bool {
+	return c.CertFile != "" || c.PKCS12File != ""
+}
+
+func (c TLSConfig) config() (*tls.Config, error) {
+	ret := &tls.Config{
+		ServerName: c.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if c.MinVersion != "" {
+		v, ok := tlsVersions[c.MinVersion]
+		if !ok {
+			return nil, WrapError(fmt.Errorf("invalid TLS minVersion: %s", c.MinVersion))
+		}
+		ret.MinVersion = v
+	}
+	if c.CAFile != "" {
+		b, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, WrapError(err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, WrapError(fmt.Errorf("no certificates in %s", c.CAFile))
+		}
+		ret.RootCAs = pool
+	}
+	return ret, nil
+}
+
+// ClientConfig returns the tls.Config of a client presenting the certificate, if any, to the security server.
+func (c TLSConfig) ClientConfig() (*tls.Config, error) {
+	ret, err := c.config()
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	if c.hasCertificate() {
+		reloader, err := newCertReloader(c)
+		if err != nil {
+			return nil, WrapError(err)
+		}
+		ret.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return reloader.certificate()
+		}
+	}
+	return ret, nil
+}
+
+// ServerConfig returns the tls.Config of a provider serving the certificate,
+// that requires the security server to present a certificate of CAFile.
+func (c TLSConfig) ServerConfig() (*tls.Config, error) {
+	if !c.hasCertificate() {
+		return nil, WrapError(errors.New("TLS certificate empty"))
+	}
+	if c.CAFile == "" {
+		return nil, WrapError(errors.New("TLS caFile empty"))
+	}
+	ret, err := c.config()
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	reloader, err := newCertReloader(c)
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	ret.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		return reloader.certificate()
+	}
+	ret.ClientCAs, ret.RootCAs = ret.RootCAs, nil
+	ret.ClientAuth = tls.RequireAndVerifyClientCert
+	return ret, nil
+}
+
+// NewTLSServer returns a server for the provider's information system, requiring mTLS from the security server.
+// Start it with ListenAndServeTLS("", "").
+func NewTLSServer(addr string, h http.Handler, c TLSConfig) (*http.Server, error) {
+	config, err := c.ServerConfig()
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	return &http.Server{
+		Addr:      addr,
+		Handler:   h,
+		TLSConfig: config,
+	}, nil
+}
+
+// NewTLSSOAPClient is like NewSOAPClient but connects with c.
+func NewTLSSOAPClient(c TLSConfig) (SOAPClient, error) {
+	config, err := c.ClientConfig()
+	if err != nil {
+		return SOAPClient{}, WrapError(err)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	ret := NewSOAPClient()
+	ret.Transport = transport
+	return ret, nil
+}
+
+// certReloader loads a certificate and loads it again when its files are modified.
+type certReloader struct {
+	config  TLSConfig
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertReloader(c TLSConfig) (*certReloader, error) {
+	r := &certReloader{config: c}
+	_, err := r.certificate()
+	return r, WrapError(err)
+}
+
+func (r *certReloader) files() []string {
+	if r.config.PKCS12File != "" {
+		return []string{r.config.PKCS12File}
+	}
+	return []string{r.config.CertFile, r.config.KeyFile}
+}
+
+// certificate returns the certificate, reloaded if any of the files changed since it was loaded.
+// If reloading fails, the certificate loaded before is used.
+func (r *certReloader) certificate() (*tls.Certificate, error) {
+	var modTime time.Time
+	for _, f := range r.files() {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return r.fallback(err)
+		}
+		if fi.ModTime().After(modTime) {
+			modTime = fi.ModTime()
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cert != nil && modTime.Equal(r.modTime) {
+		return r.cert, nil
+	}
+	cert, err := r.load()
+	if err != nil {
+		if r.cert != nil {
+			Log.Error("msg", "reloading certificate failed", "error", err)
+			return r.cert, nil
+		}
+		return nil, WrapError(err)
+	}
+	if r.cert != nil {
+		Log.Info("msg", "certificate reloaded", "files", r.files())
+	}
+	r.cert, r.modTime = &cert, modTime
+	return r.cert, nil
+}
+
+func (r *certReloader) fallback(err error) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cert != nil {
+		Log.Error("msg", "reloading certificate failed", "error", err)
+		return r.cert, nil
+	}
+	return nil, WrapError(err)
+}
+
+func (r *certReloader) load() (tls.Certificate, error) {
+	if r.config.PKCS12File == "" {
+		cert, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+		return cert, WrapError(err)
+	}
+	b, err := ioutil.ReadFile(r.config.PKCS12File)
+	if err != nil {
+		return tls.Certificate{}, WrapError(err)
+	}
+	cert, err := loadPKCS12(b, r.config.PKCS12Password)
+	return cert, WrapError(err)
+}
+
+// loadPKCS12 converts the key and certificate chain of a PKCS#12 file.
+// The bags are not ordered, so the certificate of the key is looked for.
+func loadPKCS12(b []byte, password string) (tls.Certificate, error) {
+	blocks, err := pkcs12.ToPEM(b, password)
+	if _, ok := err.(pkcs12.NotImplementedError); ok {
+		return tls.Certificate{}, WrapError(fmt.Errorf("%s, only the legacy PKCS#12 encryption is supported", err))
+	}
+	if err != nil {
+		return tls.Certificate{}, WrapError(err)
+	}
+	var certs [][]byte
+	var key []byte
+	for _, block := range blocks {
+		// tls.X509KeyPair does not like the attributes of the bags
+		block.Headers = nil
+		if block.Type == "CERTIFICATE" {
+			certs = append(certs, pem.EncodeToMemory(block))
+		} else {
+			key = pem.EncodeToMemory(block)
+		}
+	}
+	err = errors.New("no certificates in PKCS#12")
+	for i := range certs {
+		chain := append([][]byte{certs[i]}, certs[:i]...)
+		chain = append(chain, certs[i+1:]...)
+		var cert tls.Certificate
+		if cert, err = tls.X509KeyPair(bytes.Join(chain, nil), key); err == nil {
+			return cert, nil
+		}
+	}
+	return tls.Certificate{}, WrapError(err)
+}
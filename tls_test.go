@@ -0,0 +1,239 @@
+package xroad
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// newTestCert creates a certificate signed by parent, or a self signed CA if parent is nil.
+func newTestCert(t *testing.T, cn string, parent *testCert) testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	return testCert{cert: cert, key: key, der: der}
+}
+
+// write writes the certificate and key in PEM, and returns the file names.
+func (c testCert) write(t *testing.T, dir, name string) (string, string) {
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600); err != nil {
+		t.Fatalf("%s", err)
+	}
+	b, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b}), 0600); err != nil {
+		t.Fatalf("%s", err)
+	}
+	return certFile, keyFile
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xroad")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, "ca", nil)
+	caFile, _ := ca.write(t, dir, "ca")
+	serverCert, serverKey := newTestCert(t, "provider", &ca).write(t, dir, "provider")
+	clientCert, clientKey := newTestCert(t, "ss1", &ca).write(t, dir, "client")
+
+	var peers []string
+	mux := NewMux(testBody{})
+	mux.HandleFunc("*", func(w http.ResponseWriter, r *http.Request, e SOAPEnvelope) error {
+		peers = append(peers, r.TLS.PeerCertificates[0].Subject.CommonName)
+		return WriteSoap(200, e.NewResponseEnvelope(&testBody{Value: "ok"}), w)
+	})
+	srv, err := NewTLSServer("", ErrorTo500(mux), TLSConfig{CertFile: serverCert, KeyFile: serverKey, CAFile: caFile})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	go srv.ServeTLS(l, "", "")
+	defer srv.Close()
+	url := "https://" + l.Addr().String()
+
+	c, err := NewClientFromConfig(ReqConfig{
+		Url:        url,
+		SOAPHeader: SOAPHeader{Service: &XroadService{ServiceCode: "echo"}},
+		TLS:        &TLSConfig{CertFile: clientCert, KeyFile: clientKey, CAFile: caFile, MinVersion: "1.3"},
+	})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	send := func(c Client) error {
+		res, err := c.Send(c.CloneHeader(), &testBody{}, &SOAPEnvelope{Body: &testBody{}})
+		if err == nil {
+			// the connection must be idle to be closed by CloseIdleConnections
+			res.Body.Close()
+		}
+		return err
+	}
+	if err := send(c); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	// the certificate is renewed on disk
+	newTestCert(t, "ss2", &ca).write(t, dir, "client")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(clientCert, later, later)
+	c.CloseIdleConnections()
+	if err := send(c); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(peers) != 2 || peers[0] != "ss1" || peers[1] != "ss2" {
+		t.Errorf("expected the reloaded certificate, got %v", peers)
+	}
+
+	noCert, err := NewClientFromConfig(ReqConfig{
+		Url:        url,
+		SOAPHeader: c.CloneHeader(),
+		TLS:        &TLSConfig{CAFile: caFile},
+	})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := send(noCert); err == nil {
+		t.Errorf("expected the server to require a client certificate")
+	}
+}
+
+// readPKCS12 decodes a base64 PKCS#12 file of testdata, as ss1 signed by ca with the password secret.
+// x/crypto/pkcs12 can not encode, so the files are made with openssl pkcs12 -export.
+func readPKCS12(t *testing.T, name string) []byte {
+	b, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	der, err := base64.StdEncoding.DecodeString(string(b))
+	if err != nil {
+		t.Fatalf("%s: %s", name, err)
+	}
+	return der
+}
+
+func TestPKCS12(t *testing.T) {
+	b := readPKCS12(t, "ss1.p12.b64")
+	cert, err := loadPKCS12(b, "secret")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(cert.Certificate) != 2 {
+		t.Fatalf("expected the certificate and the CA, got %d certificates", len(cert.Certificate))
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if leaf.Subject.CommonName != "ss1" || leaf.Issuer.CommonName != "ca" {
+		t.Errorf("unexpected certificate %s issued by %s", leaf.Subject, leaf.Issuer)
+	}
+
+	if _, err := loadPKCS12(b, "wrong"); err == nil {
+		t.Errorf("expected an error with a wrong password")
+	}
+	// openssl 3 encrypts with PBES2 and AES by default
+	_, err = loadPKCS12(readPKCS12(t, "ss1-aes.p12.b64"), "secret")
+	if err == nil || !strings.Contains(err.Error(), "legacy") {
+		t.Errorf("expected an error about the legacy encryption, got %v", err)
+	}
+
+	// the file is read by the client config
+	dir, err := ioutil.TempDir("", "xroad")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "ss1.p12")
+	if err := ioutil.WriteFile(file, b, 0600); err != nil {
+		t.Fatalf("%s", err)
+	}
+	config, err := TLSConfig{PKCS12File: file, PKCS12Password: "secret"}.ClientConfig()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	got, err := config.GetClientCertificate(nil)
+	if err != nil || !bytes.Equal(got.Certificate[0], cert.Certificate[0]) {
+		t.Errorf("unexpected client certificate: %v", err)
+	}
+}
+
+func TestTLSMinVersion(t *testing.T) {
+	tests := []struct {
+		in   string
+		want uint16
+	}{
+		{"", tls.VersionTLS12},
+		{"1.2", tls.VersionTLS12},
+		{"1.3", tls.VersionTLS13},
+		{"1.0", 0},
+		{"1.1", 0},
+		{"TLS1.3", 0},
+	}
+	for _, test := range tests {
+		config, err := TLSConfig{MinVersion: test.in}.ClientConfig()
+		if test.want == 0 {
+			if err == nil {
+				t.Errorf("%q: expected an error", test.in)
+			}
+			continue
+		}
+		if err != nil || config.MinVersion != test.want {
+			t.Errorf("%q: unexpected %v, %v", test.in, config, err)
+		}
+	}
+}
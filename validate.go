@@ -0,0 +1,68 @@
+package xroad
+
+import (
+	"fmt"
+)
+
+// SupportedProtocolVersions are the protocolVersions Client accepts in responses with ValidateResponse.
+var SupportedProtocolVersions = []string{"4.0"}
+
+// HeaderMismatchError tells that a response header does not match the request,
+// like a misrouted or replayed response.
+type HeaderMismatchError struct {
+	Field    string
+	Expected string
+	Actual   string
+}
+
+func (e HeaderMismatchError) Error() string {
+	return fmt.Sprintf("response %s %q does not match %q", e.Field, e.Actual, e.Expected)
+}
+
+// validateResponseHeader checks that res echoes the request header req.
+// For a request to a central service, the security server resolves it to a service of a provider,
+// so the response only has to echo the centralService, if it has one, and can have any service.
+func validateResponseHeader(req, res SOAPHeader) error {
+	supported := false
+	for _, v := range SupportedProtocolVersions {
+		if res.ProtocolVersion == v {
+			supported = true
+		}
+	}
+	if !supported {
+		return WrapError(HeaderMismatchError{
+			Field:    "protocolVersion",
+			Expected: fmt.Sprintf("%v", SupportedProtocolVersions),
+			Actual:   res.ProtocolVersion,
+		})
+	}
+	if res.Id != req.Id {
+		return WrapError(HeaderMismatchError{Field: "id", Expected: req.Id, Actual: res.Id})
+	}
+	if res.UserId != req.UserId {
+		return WrapError(HeaderMismatchError{Field: "userId", Expected: req.UserId, Actual: res.UserId})
+	}
+	if !res.Client.Equal(req.Client) {
+		return WrapError(HeaderMismatchError{Field: "client", Expected: req.Client.Fqdn(), Actual: res.Client.Fqdn()})
+	}
+
+	if central := req.CentralService; central != nil {
+		if rc := res.CentralService; rc != nil && (rc.XRoadInstance != central.XRoadInstance || rc.ServiceCode != central.ServiceCode) {
+			return WrapError(HeaderMismatchError{
+				Field:    "centralService",
+				Expected: central.XRoadInstance + "." + central.ServiceCode,
+				Actual:   rc.XRoadInstance + "." + rc.ServiceCode,
+			})
+		}
+		return nil
+	}
+	if req.Service != nil {
+		if res.Service == nil {
+			return WrapError(HeaderMismatchError{Field: "service", Expected: req.Service.Fqdn()})
+		}
+		if !res.Service.Equal(*req.Service) {
+			return WrapError(HeaderMismatchError{Field: "service", Expected: req.Service.Fqdn(), Actual: res.Service.Fqdn()})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,471 @@
+package xroad
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+const nsXroad = "http://x-road.eu/xsd/xroad.xsd"
+
+// Operation describes a service provided by a Mux, for WSDL generation.
+// Request and Response are values of the types of the element inside the SOAP Body.
+// Their XMLName defines the element names, which default to ServiceCode and
+// ServiceCode+"Response" in the target namespace.
+type Operation struct {
+	ServiceCode string
+	Version     string
+	Title       string
+	Request     interface{}
+	Response    interface{}
+}
+
+// HandleOperation registers h for op.ServiceCode like Handle,
+// and remembers op to be described in the WSDL.
+func (m *Mux) HandleOperation(op Operation, h SOAPHandler) {
+	m.Handle(op.ServiceCode, h)
+	m.operations[op.ServiceCode] = op
+}
+
+// WSDL is a document/literal WSDL describing X-Road services.
+type WSDL struct {
+	Name            string
+	TargetNamespace string
+	Location        string // soap:address of the service
+	Operations      []Operation
+}
+
+// WSDL returns the WSDL of the operations registered by HandleOperation.
+func (m *Mux) WSDL(name, targetNamespace, location string) WSDL {
+	codes := make([]string, 0, len(m.operations))
+	for code := range m.operations {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	w := WSDL{
+		Name:            name,
+		TargetNamespace: targetNamespace,
+		Location:        location,
+	}
+	for _, code := range codes {
+		w.Operations = append(w.Operations, m.operations[code])
+	}
+	return w
+}
+
+// ServeHTTP serves the WSDL, so that the security server can download it by URL.
+func (w WSDL) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	b, err := w.Generate()
+	if err != nil {
+		Log.Error("error", WrapError(err))
+		http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	rw.Write(b)
+}
+
+// HandleGetWsdl makes the Mux answer getWsdl with w.
+// The WSDL is sent as an attachment, as the security server does.
+func (m *Mux) HandleGetWsdl(w WSDL) {
+	m.HandleFunc(ServiceGetWsdl, func(rw http.ResponseWriter, r *http.Request, e SOAPEnvelope) error {
+		// the Mux decoded the body into its own body type, decode again to get the requested service
+		var req getWsdlBody
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return WrapError(err)
+		}
+		if err := xml.Unmarshal(b, &SOAPEnvelope{Body: &req}); err != nil {
+			return WrapError(err)
+		}
+
+		wsdl, err := w.Generate()
+		if err != nil {
+			return WrapError(err)
+		}
+		xop, err := NewXOP()
+		if err != nil {
+			return WrapError(err)
+		}
+		if _, err := xop.AddFile(fmt.Sprintf("%s.wsdl", w.Name), bytes.NewReader(wsdl)); err != nil {
+			return WrapError(err)
+		}
+
+		res := e.NewResponseEnvelope(getWsdlResponseBody{
+			GetWsdlResponse: req.GetWsdl,
+		})
+		res.XOP = &xop
+		return WrapError(WriteSoap(http.StatusOK, res, rw))
+	})
+}
+
+func (op Operation) elementName(v interface{}, suffix string) string {
+	if v != nil {
+		t := indirectType(reflect.TypeOf(v))
+		if t.Kind() != reflect.Struct {
+			return op.ServiceCode + suffix
+		}
+		if f, ok := t.FieldByName("XMLName"); ok {
+			if _, name := xmlTagName(f.Tag.Get("xml")); name != "" {
+				return name
+			}
+		}
+	}
+	return op.ServiceCode + suffix
+}
+
+// Generate writes the WSDL.
+func (w WSDL) Generate() ([]byte, error) {
+	s := &schemaWriter{
+		targetNamespace: w.TargetNamespace,
+		types:           make(map[reflect.Type]string),
+		names:           make(map[string]bool),
+	}
+	var elements bytes.Buffer
+	for _, op := range w.Operations {
+		for _, v := range []struct {
+			value  interface{}
+			suffix string
+		}{{op.Request, ""}, {op.Response, "Response"}} {
+			name := op.elementName(v.value, v.suffix)
+			if v.value == nil {
+				fmt.Fprintf(&elements, "<xsd:element name=%s><xsd:complexType><xsd:sequence/></xsd:complexType></xsd:element>\n", attr(name))
+				continue
+			}
+			if err := s.checkNamespace(indirectType(reflect.TypeOf(v.value))); err != nil {
+				return nil, WrapError(err)
+			}
+			typ, err := s.typeName(reflect.TypeOf(v.value))
+			if err != nil {
+				return nil, WrapError(err)
+			}
+			fmt.Fprintf(&elements, "<xsd:element name=%s type=%s/>\n", attr(name), attr(typ))
+		}
+	}
+
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	fmt.Fprintf(&b, `<wsdl:definitions name=%s targetNamespace=%s xmlns:tns=%s`,
+		attr(w.Name), attr(w.TargetNamespace), attr(w.TargetNamespace))
+	b.WriteString(` xmlns:wsdl="http://schemas.xmlsoap.org/wsdl/" xmlns:soap="http://schemas.xmlsoap.org/wsdl/soap/"`)
+	b.WriteString(` xmlns:xsd="http://www.w3.org/2001/XMLSchema" xmlns:xmime="http://www.w3.org/2005/05/xmlmime"`)
+	fmt.Fprintf(&b, " xmlns:xrd=%s>\n", attr(nsXroad))
+
+	b.WriteString("<wsdl:types>\n")
+	fmt.Fprintf(&b, "<xsd:schema targetNamespace=%s elementFormDefault=\"qualified\">\n", attr(w.TargetNamespace))
+	fmt.Fprintf(&b, "<xsd:import namespace=%s schemaLocation=%s/>\n", attr(nsXroad), attr(nsXroad))
+	b.Write(elements.Bytes())
+	b.Write(s.complexTypes.Bytes())
+	b.WriteString("</xsd:schema>\n</wsdl:types>\n")
+
+	b.WriteString("<wsdl:message name=\"requestheader\">\n")
+	for _, part := range xroadHeaderParts {
+		fmt.Fprintf(&b, "<wsdl:part name=%s element=%s/>\n", attr(part), attr("xrd:"+part))
+	}
+	b.WriteString("</wsdl:message>\n")
+	for _, op := range w.Operations {
+		for _, name := range []string{op.elementName(op.Request, ""), op.elementName(op.Response, "Response")} {
+			fmt.Fprintf(&b, "<wsdl:message name=%s><wsdl:part name=\"body\" element=%s/></wsdl:message>\n", attr(name), attr("tns:"+name))
+		}
+	}
+
+	fmt.Fprintf(&b, "<wsdl:portType name=%s>\n", attr(w.Name+"PortType"))
+	for _, op := range w.Operations {
+		fmt.Fprintf(&b, "<wsdl:operation name=%s>\n", attr(op.ServiceCode))
+		if op.Title != "" {
+			fmt.Fprintf(&b, "<wsdl:documentation><xrd:title>%s</xrd:title></wsdl:documentation>\n", text(op.Title))
+		}
+		fmt.Fprintf(&b, "<wsdl:input message=%s/>\n", attr("tns:"+op.elementName(op.Request, "")))
+		fmt.Fprintf(&b, "<wsdl:output message=%s/>\n", attr("tns:"+op.elementName(op.Response, "Response")))
+		b.WriteString("</wsdl:operation>\n")
+	}
+	b.WriteString("</wsdl:portType>\n")
+
+	fmt.Fprintf(&b, "<wsdl:binding name=%s type=%s>\n", attr(w.Name+"Binding"), attr("tns:"+w.Name+"PortType"))
+	b.WriteString("<soap:binding style=\"document\" transport=\"http://schemas.xmlsoap.org/soap/http\"/>\n")
+	for _, op := range w.Operations {
+		fmt.Fprintf(&b, "<wsdl:operation name=%s>\n", attr(op.ServiceCode))
+		b.WriteString("<soap:operation soapAction=\"\" style=\"document\"/>\n")
+		if op.Version != "" {
+			fmt.Fprintf(&b, "<xrd:version>%s</xrd:version>\n", text(op.Version))
+		}
+		for _, dir := range []string{"input", "output"} {
+			fmt.Fprintf(&b, "<wsdl:%s>\n<soap:body parts=\"body\" use=\"literal\"/>\n", dir)
+			for _, part := range xroadHeaderParts {
+				fmt.Fprintf(&b, "<soap:header message=\"tns:requestheader\" part=%s use=\"literal\"/>\n", attr(part))
+			}
+			fmt.Fprintf(&b, "</wsdl:%s>\n", dir)
+		}
+		b.WriteString("</wsdl:operation>\n")
+	}
+	b.WriteString("</wsdl:binding>\n")
+
+	fmt.Fprintf(&b, "<wsdl:service name=%s>\n", attr(w.Name+"Service"))
+	fmt.Fprintf(&b, "<wsdl:port name=%s binding=%s>\n", attr(w.Name+"Port"), attr("tns:"+w.Name+"Binding"))
+	fmt.Fprintf(&b, "<soap:address location=%s/>\n", attr(w.Location))
+	b.WriteString("</wsdl:port>\n</wsdl:service>\n</wsdl:definitions>\n")
+
+	return b.Bytes(), nil
+}
+
+var xroadHeaderParts = []string{"client", "service", "userId", "id", "protocolVersion"}
+
+func attr(s string) string {
+	return `"` + text(s) + `"`
+}
+
+func text(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+// xmlTagName splits the name part of a xml struct tag into namespace and local name.
+func xmlTagName(tag string) (string, string) {
+	name := strings.Split(tag, ",")[0]
+	if i := strings.Index(name, " "); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return "", name
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	xmlNameType    = reflect.TypeOf(xml.Name{})
+	xopIncludeType = reflect.TypeOf(XOPInclude{})
+)
+
+// schemaWriter writes xsd:complexTypes for go types.
+type schemaWriter struct {
+	targetNamespace string
+	types           map[reflect.Type]string // go type to xsd type name
+	names           map[string]bool
+	complexTypes    bytes.Buffer
+}
+
+func (s *schemaWriter) checkNamespace(t reflect.Type) error {
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	if f, ok := t.FieldByName("XMLName"); ok {
+		if ns, _ := xmlTagName(f.Tag.Get("xml")); ns != "" && ns != s.targetNamespace {
+			return fmt.Errorf("%s: namespace %s differs from the target namespace", t, ns)
+		}
+	}
+	return nil
+}
+
+// simpleType returns the xsd type of t, or an empty string if t is not a simple type.
+func simpleType(t reflect.Type) string {
+	if t == timeType {
+		return "xsd:dateTime"
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "xsd:string"
+	case reflect.Bool:
+		return "xsd:boolean"
+	case reflect.Int, reflect.Int64:
+		return "xsd:long"
+	case reflect.Int32:
+		return "xsd:int"
+	case reflect.Int16:
+		return "xsd:short"
+	case reflect.Int8:
+		return "xsd:byte"
+	case reflect.Uint, reflect.Uint64:
+		return "xsd:unsignedLong"
+	case reflect.Uint32:
+		return "xsd:unsignedInt"
+	case reflect.Uint16:
+		return "xsd:unsignedShort"
+	case reflect.Uint8:
+		return "xsd:unsignedByte"
+	case reflect.Float32:
+		return "xsd:float"
+	case reflect.Float64:
+		return "xsd:double"
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "xsd:base64Binary"
+		}
+	case reflect.Interface:
+		return "xsd:anyType"
+	}
+	return ""
+}
+
+// isXOPElement reports whether t is a struct holding a xop:Include,
+// which is described as xsd:base64Binary.
+func isXOPElement(t reflect.Type) bool {
+	if t.Kind() != reflect.Struct {
+		return false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if indirectType(t.Field(i).Type) == xopIncludeType {
+			return true
+		}
+	}
+	return false
+}
+
+// typeName returns the qualified xsd type name of t, writing a complexType if necessary.
+func (s *schemaWriter) typeName(t reflect.Type) (string, error) {
+	t = indirectType(t)
+	if st := simpleType(t); st != "" {
+		return st, nil
+	}
+	if t.Kind() != reflect.Struct {
+		return "", fmt.Errorf("%s: unsupported type", t)
+	}
+	if name, ok := s.types[t]; ok {
+		return name, nil
+	}
+
+	name := t.Name()
+	if name == "" {
+		name = "Type"
+	}
+	for i := 2; s.names[name]; i++ {
+		name = fmt.Sprintf("%s%d", strings.TrimRight(name, "0123456789"), i)
+	}
+	s.names[name] = true
+	s.types[t] = "tns:" + name
+
+	var b bytes.Buffer
+	if err := s.writeComplexType(&b, t, name); err != nil {
+		return "", err
+	}
+	s.complexTypes.Write(b.Bytes())
+	return "tns:" + name, nil
+}
+
+type schemaFields struct {
+	elements   bytes.Buffer
+	attributes bytes.Buffer
+	chardata   string
+}
+
+func (s *schemaWriter) writeComplexType(b *bytes.Buffer, t reflect.Type, name string) error {
+	var fields schemaFields
+	if err := s.collectFields(&fields, t); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(b, "<xsd:complexType name=%s>\n", attr(name))
+	if fields.chardata != "" {
+		fmt.Fprintf(b, "<xsd:simpleContent><xsd:extension base=%s>\n", attr(fields.chardata))
+		b.Write(fields.attributes.Bytes())
+		b.WriteString("</xsd:extension></xsd:simpleContent>\n")
+	} else {
+		b.WriteString("<xsd:sequence>\n")
+		b.Write(fields.elements.Bytes())
+		b.WriteString("</xsd:sequence>\n")
+		b.Write(fields.attributes.Bytes())
+	}
+	b.WriteString("</xsd:complexType>\n")
+	return nil
+}
+
+func (s *schemaWriter) collectFields(fields *schemaFields, t reflect.Type) error {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue // unexported
+		}
+		tag := f.Tag.Get("xml")
+		if tag == "-" || f.Type == xmlNameType {
+			continue
+		}
+		opts := strings.Split(tag, ",")[1:]
+		ns, name := xmlTagName(tag)
+		if f.Anonymous && tag == "" && indirectType(f.Type).Kind() == reflect.Struct {
+			if err := s.collectFields(fields, indirectType(f.Type)); err != nil {
+				return err
+			}
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if strings.Contains(name, ">") {
+			return fmt.Errorf("%s.%s: nested element paths are not supported", t, f.Name)
+		}
+		if ns != "" && ns != s.targetNamespace {
+			return fmt.Errorf("%s.%s: namespace %s differs from the target namespace", t, f.Name, ns)
+		}
+		omitempty := false
+		kind := ""
+		for _, opt := range opts {
+			switch opt {
+			case "omitempty":
+				omitempty = true
+			case "attr", "chardata", "innerxml", "comment", "any", "cdata":
+				kind = opt
+			}
+		}
+
+		ft := indirectType(f.Type)
+		switch kind {
+		case "attr":
+			typ := simpleType(ft)
+			if typ == "" {
+				return fmt.Errorf("%s.%s: unsupported attribute type", t, f.Name)
+			}
+			use := "required"
+			if omitempty || f.Type.Kind() == reflect.Ptr {
+				use = "optional"
+			}
+			fmt.Fprintf(&fields.attributes, "<xsd:attribute name=%s type=%s use=%s/>\n", attr(name), attr(typ), attr(use))
+			continue
+		case "chardata", "cdata":
+			typ := simpleType(ft)
+			if typ == "" {
+				typ = "xsd:string"
+			}
+			fields.chardata = typ
+			continue
+		case "innerxml", "any":
+			fields.elements.WriteString("<xsd:any minOccurs=\"0\" maxOccurs=\"unbounded\" processContents=\"lax\"/>\n")
+			continue
+		case "comment":
+			continue
+		}
+
+		minOccurs, maxOccurs := "1", "1"
+		if omitempty || f.Type.Kind() == reflect.Ptr {
+			minOccurs = "0"
+		}
+		if ft.Kind() == reflect.Slice && ft.Elem().Kind() != reflect.Uint8 {
+			ft = indirectType(ft.Elem())
+			minOccurs, maxOccurs = "0", "unbounded"
+		}
+
+		if isXOPElement(ft) {
+			fmt.Fprintf(&fields.elements, "<xsd:element name=%s type=\"xsd:base64Binary\" xmime:expectedContentTypes=\"application/octet-stream\" minOccurs=%s maxOccurs=%s/>\n",
+				attr(name), attr(minOccurs), attr(maxOccurs))
+			continue
+		}
+		typ, err := s.typeName(ft)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&fields.elements, "<xsd:element name=%s type=%s minOccurs=%s maxOccurs=%s/>\n",
+			attr(name), attr(typ), attr(minOccurs), attr(maxOccurs))
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package xroad
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testNamespace = "http://example.com"
+
+type wsdlPerson struct {
+	XMLName xml.Name `xml:"http://example.com getPerson"`
+	Code    string   `xml:"http://example.com code"`
+	Lang    string   `xml:"lang,attr,omitempty"`
+}
+
+type wsdlPersonResponse struct {
+	XMLName xml.Name    `xml:"http://example.com getPersonResponse"`
+	Names   []string    `xml:"http://example.com name"`
+	Photo   *XOPElement `xml:"http://example.com photo,omitempty"`
+}
+
+// the parts of a WSDL checked by the tests
+type testWSDL struct {
+	Schema struct {
+		Elements []struct {
+			Name string `xml:"name,attr"`
+			Type string `xml:"type,attr"`
+		} `xml:"http://www.w3.org/2001/XMLSchema element"`
+		ComplexTypes []struct {
+			Name     string `xml:"name,attr"`
+			Elements []struct {
+				Name      string `xml:"name,attr"`
+				Type      string `xml:"type,attr"`
+				MinOccurs string `xml:"minOccurs,attr"`
+				MaxOccurs string `xml:"maxOccurs,attr"`
+			} `xml:"http://www.w3.org/2001/XMLSchema sequence>element"`
+			Attributes []struct {
+				Name string `xml:"name,attr"`
+				Use  string `xml:"use,attr"`
+			} `xml:"http://www.w3.org/2001/XMLSchema attribute"`
+		} `xml:"http://www.w3.org/2001/XMLSchema complexType"`
+	} `xml:"types>schema"`
+	Operations []struct {
+		Name  string `xml:"name,attr"`
+		Title string `xml:"documentation>title"`
+	} `xml:"http://schemas.xmlsoap.org/wsdl/ portType>operation"`
+	Bindings []struct {
+		Name    string `xml:"name,attr"`
+		Version string `xml:"http://x-road.eu/xsd/xroad.xsd version"`
+	} `xml:"http://schemas.xmlsoap.org/wsdl/ binding>operation"`
+	Address struct {
+		Location string `xml:"location,attr"`
+	} `xml:"service>port>address"`
+}
+
+func testMux() *Mux {
+	mux := NewMux(testBody{})
+	ok := func(w http.ResponseWriter, r *http.Request, e SOAPEnvelope) error {
+		return WriteSoap(200, e.NewResponseEnvelope(&testBody{Value: "ok"}), w)
+	}
+	mux.HandleOperation(Operation{
+		ServiceCode: "getPerson",
+		Version:     "v1",
+		Title:       "Get a person",
+		Request:     wsdlPerson{},
+		Response:    wsdlPersonResponse{},
+	}, SOAPHandlerFunc(ok))
+	mux.HandleOperation(Operation{ServiceCode: "ping", Version: "v2"}, SOAPHandlerFunc(ok))
+	return mux
+}
+
+func TestWSDLGenerate(t *testing.T) {
+	b, err := testMux().WSDL("person", testNamespace, "http://localhost/").Generate()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	var w testWSDL
+	if err := xml.Unmarshal(b, &w); err != nil {
+		t.Fatalf("%s\n%s", err, b)
+	}
+
+	var elements []string
+	for _, e := range w.Schema.Elements {
+		elements = append(elements, e.Name+":"+e.Type)
+	}
+	if got := strings.Join(elements, ","); got != "getPerson:tns:wsdlPerson,getPersonResponse:tns:wsdlPersonResponse,ping:,pingResponse:" {
+		t.Errorf("unexpected elements %s", got)
+	}
+
+	fields := make(map[string]string)
+	for _, ct := range w.Schema.ComplexTypes {
+		for _, e := range ct.Elements {
+			fields[ct.Name+"."+e.Name] = e.Type + " " + e.MinOccurs + ".." + e.MaxOccurs
+		}
+		for _, a := range ct.Attributes {
+			fields[ct.Name+"@"+a.Name] = a.Use
+		}
+	}
+	for name, want := range map[string]string{
+		"wsdlPerson.code":         "xsd:string 1..1",
+		"wsdlPerson@lang":         "optional",
+		"wsdlPersonResponse.name": "xsd:string 0..unbounded",
+		// attachments are described as base64Binary
+		"wsdlPersonResponse.photo": "xsd:base64Binary 0..1",
+	} {
+		if fields[name] != want {
+			t.Errorf("%s: expected %q, got %q", name, want, fields[name])
+		}
+	}
+	if len(fields) != 4 {
+		t.Errorf("unexpected fields %v", fields)
+	}
+
+	if len(w.Operations) != 2 || w.Operations[0].Name != "getPerson" || w.Operations[0].Title != "Get a person" || w.Operations[1].Name != "ping" {
+		t.Errorf("unexpected operations %+v", w.Operations)
+	}
+	if len(w.Bindings) != 2 || w.Bindings[0].Version != "v1" || w.Bindings[1].Version != "v2" {
+		t.Errorf("unexpected bindings %+v", w.Bindings)
+	}
+	if w.Address.Location != "http://localhost/" {
+		t.Errorf("unexpected address %q", w.Address.Location)
+	}
+}
+
+func TestWSDLGenerateNamespace(t *testing.T) {
+	type other struct {
+		Code string `xml:"http://other.example.com code"`
+	}
+	w := WSDL{
+		Name:            "person",
+		TargetNamespace: testNamespace,
+		Operations:      []Operation{{ServiceCode: "getPerson", Request: other{}}},
+	}
+	if _, err := w.Generate(); err == nil {
+		t.Errorf("expected an error for a field in another namespace")
+	}
+}
+
+func TestMuxGetWsdl(t *testing.T) {
+	mux := testMux()
+	mux.HandleGetWsdl(mux.WSDL("person", testNamespace, "http://localhost/"))
+	srv := httptest.NewServer(ErrorTo500(mux))
+	defer srv.Close()
+
+	c := NewClient(srv.URL, SOAPHeader{
+		Client: XroadClient{XRoadInstance: "EE", MemberClass: "GOV", MemberCode: "1", SubsystemCode: "c"},
+	})
+	b, err := c.GetWsdl(context.Background(), XroadService{XroadClient: testProvider, ServiceCode: "getPerson", ServiceVersion: "v1"})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	var w testWSDL
+	if err := xml.Unmarshal(b, &w); err != nil {
+		t.Fatalf("%s\n%s", err, b)
+	}
+	if len(w.Operations) != 2 || w.Operations[0].Name != "getPerson" || len(w.Schema.ComplexTypes) != 2 {
+		t.Errorf("unexpected WSDL\n%s", b)
+	}
+}
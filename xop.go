@@ -2,6 +2,7 @@ package xroad
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/xml"
 	"fmt"
@@ -11,6 +12,8 @@ import (
 	"mime/multipart"
 	"net/http"
 	"net/textproto"
+	"net/url"
+	"os"
 	"strings"
 
 	"github.com/google/uuid"
@@ -19,12 +22,52 @@ import (
 type XOP struct {
 	SOAPEnvelope SOAPEnvelope
 	Boundary     string
-	Files        []xopFile
+	Files        []XOPFile
+	// Format is the format the message is written in, and the format a decoded message was received in.
+	Format XOPFormat
+
+	// set while decoding, see NextFile
+	reader  *multipart.Reader
+	total   *limitReader
+	options DecodeOptions
+	next    int
+	spooled []*os.File
 }
 
-type xopFile struct {
+// XOPFile is an attachment.
+// ContentId is without the surrounding '<' and '>' of the Content-ID header.
+type XOPFile struct {
 	ContentId, Filename string
 	File                io.Reader
+	// Encoding is the Content-Transfer-Encoding the file is sent with, base64 if empty.
+	// Decoded files keep the encoding they were received with, File reads the decoded bytes.
+	Encoding TransferEncoding
+	offset   int64 // where to rewind File to, see prepareReplay
+}
+
+type TransferEncoding string
+
+const (
+	TransferEncodingBase64 TransferEncoding = "base64"
+	// TransferEncodingBinary sends the file as is, which saves the base64 overhead.
+	TransferEncodingBinary TransferEncoding = "binary"
+	// TransferEncoding8bit sends the file as is, for text with lines shorter than 1000 bytes.
+	TransferEncoding8bit TransferEncoding = "8bit"
+)
+
+func (f XOPFile) encoding() TransferEncoding {
+	if f.Encoding == "" {
+		return TransferEncodingBase64
+	}
+	return f.Encoding
+}
+
+// encodedSize is the number of bytes size bytes take in the message.
+func (e TransferEncoding) encodedSize(size int64) int64 {
+	if e == TransferEncodingBase64 {
+		return int64(base64.StdEncoding.EncodedLen(int(size)))
+	}
+	return size
 }
 
 func NewXOP() (XOP, error) {
@@ -41,7 +84,31 @@ type FileIncluder interface {
 	IncludeFile(string)
 }
 
+// FilesIncluder is implemented by bodies referring to several attachments.
+// IncludeFiles is called with the Content-IDs of the files in the order they were added.
+type FilesIncluder interface {
+	IncludeFiles([]string)
+}
+
+// includeFiles tells body about the Content-IDs of the attachments.
+// Bodies implementing only FileIncluder get IncludeFile called for each attachment.
+func includeFiles(body interface{}, cids []string) {
+	switch b := body.(type) {
+	case FilesIncluder:
+		b.IncludeFiles(cids)
+	case FileIncluder:
+		for _, cid := range cids {
+			b.IncludeFile(cid)
+		}
+	}
+}
+
 func NewXOPRequestFromReader(url string, header SOAPHeader, body FileIncluder, r io.Reader, filename string) (*http.Request, error) {
+	req, err := NewXOPRequestFromReaderWithContext(context.Background(), url, header, body, r, filename)
+	return req, WrapError(err)
+}
+
+func NewXOPRequestFromReaderWithContext(ctx context.Context, url string, header SOAPHeader, body FileIncluder, r io.Reader, filename string) (*http.Request, error) {
 	xop, err := NewXOP()
 	if err != nil {
 		return nil, WrapError(err)
@@ -54,40 +121,247 @@ func NewXOPRequestFromReader(url string, header SOAPHeader, body FileIncluder, r
 	body.IncludeFile(cid)
 	xop.SOAPEnvelope = NewEnvelope(header, body)
 
-	return NewXOPRequest(url, header, xop)
+	return NewXOPRequestWithContext(ctx, url, header, xop)
+}
+
+// NewXOPRequestFromFiles creates a request with files attached.
+// Files without a ContentId get a new one. If body implements FilesIncluder or FileIncluder,
+// it is told about the Content-IDs before it is encoded.
+func NewXOPRequestFromFiles(ctx context.Context, url string, header SOAPHeader, body interface{}, files []XOPFile) (*http.Request, error) {
+	xop, err := NewXOP()
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	cids, err := xop.AddFiles(files...)
+	if err != nil {
+		return nil, WrapError(err)
+	}
+
+	includeFiles(body, cids)
+	xop.SOAPEnvelope = NewEnvelope(header, body)
+
+	return NewXOPRequestWithContext(ctx, url, header, xop)
 }
 
 func NewXOPRequest(url string, header SOAPHeader, xop XOP) (*http.Request, error) {
+	req, err := NewXOPRequestWithContext(context.Background(), url, header, xop)
+	return req, WrapError(err)
+}
+
+func NewXOPRequestWithContext(ctx context.Context, url string, header SOAPHeader, xop XOP) (*http.Request, error) {
 	buf := &bytes.Buffer{}
 	_, err := xop.WriteTo(buf)
 	if err != nil {
 		return nil, WrapError(err)
 	}
-	req, err := http.NewRequest("POST", url, buf)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, buf)
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	req.Header.Set("Content-Type", xop.ContentType())
+	req.Header.Set("User-Agent", UserAgent)
+
+	return req, nil
+}
+
+// XOPFormat selects how the SOAP part and the attachments are packaged.
+type XOPFormat int
+
+const (
+	// XOPMTOM refers to attachments by xop:Include elements,
+	// and sends the SOAP part as application/xop+xml.
+	XOPMTOM XOPFormat = iota
+	// XOPSwA is SOAP with Attachments, used by some older providers.
+	// Attachments are referred to by "cid:" hrefs in the body, and the SOAP part is sent as text/xml.
+	// Use XOP.File to look up an attachment by its href.
+	XOPSwA
+)
+
+func (f XOPFormat) String() string {
+	if f == XOPSwA {
+		return "SwA"
+	}
+	return "MTOM"
+}
+
+// XOPStreaming selects how the body of XOP requests is sent.
+type XOPStreaming int
+
+const (
+	// XOPBuffered writes the whole body into memory before sending it.
+	XOPBuffered XOPStreaming = iota
+	// XOPChunked streams the body with chunked Transfer-Encoding.
+	XOPChunked
+	// XOPContentLength streams the body with a precomputed Content-Length.
+	// It falls back to XOPChunked if the size of a file is unknown, see XOP.ContentLength.
+	XOPContentLength
+)
+
+// NewStreamingXOPRequest creates a request whose body is written by xop.WriteTo
+// while the request is sent, so that large files are not held in memory.
+func NewStreamingXOPRequest(ctx context.Context, url string, header SOAPHeader, xop XOP, streaming XOPStreaming) (*http.Request, error) {
+	if streaming == XOPBuffered {
+		req, err := NewXOPRequestWithContext(ctx, url, header, xop)
+		return req, WrapError(err)
+	}
+
+	var length int64 = -1
+	if streaming == XOPContentLength {
+		if n, ok := xop.ContentLength(); ok {
+			length = n
+		}
+	}
+
+	pr, pw := io.Pipe()
+	req, err := http.NewRequestWithContext(ctx, "POST", url, pr)
 	if err != nil {
 		return nil, WrapError(err)
 	}
+	req.ContentLength = length
 	req.Header.Set("Content-Type", xop.ContentType())
 	req.Header.Set("User-Agent", UserAgent)
 
+	// The transport closes the body if the request fails,
+	// which makes WriteTo fail and the goroutine exit.
+	go func() {
+		_, err := xop.WriteTo(pw)
+		pw.CloseWithError(err)
+	}()
 	return req, nil
 }
 
 func (x *XOP) AddFile(filename string, r io.Reader) (string, error) {
-	uuid, err := uuid.NewUUID()
+	cids, err := x.AddFiles(XOPFile{
+		Filename: filename,
+		File:     r,
+	})
 	if err != nil {
 		return "", WrapError(err)
 	}
-	cid := uuid.String()
-	x.Files = append(x.Files, xopFile{
-		ContentId: cid,
-		Filename:  filename,
-		File:      r,
-	})
-	return cid, nil
+	return cids[0], nil
+}
+
+// AddFiles adds files and returns their Content-IDs.
+// Files without a ContentId get a new one.
+func (x *XOP) AddFiles(files ...XOPFile) ([]string, error) {
+	cids := make([]string, 0, len(files))
+	for _, file := range files {
+		if file.ContentId == "" {
+			uuid, err := uuid.NewUUID()
+			if err != nil {
+				return nil, WrapError(err)
+			}
+			file.ContentId = uuid.String()
+		}
+		file.ContentId = normalizeContentId(file.ContentId)
+		x.Files = append(x.Files, file)
+		cids = append(cids, file.ContentId)
+	}
+	return cids, nil
+}
+
+// normalizeContentId removes the "cid:" prefix of a href or the '<' and '>' of a Content-ID header.
+func normalizeContentId(cid string) string {
+	if strings.HasPrefix(cid, "cid:") {
+		cid = strings.TrimPrefix(cid, "cid:")
+		// cid URLs are URL encoded, see RFC 2392
+		if unescaped, err := url.PathUnescape(cid); err == nil {
+			cid = unescaped
+		}
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(cid, "<"), ">")
+}
+
+// File returns the attachment referred to by cid,
+// which can be a Content-ID with or without '<' and '>', or a "cid:" href of a xop:Include.
+// When decoded with AttachmentsLazy, File reads ahead until the attachment is found,
+// which makes the attachments before it unreadable.
+func (x *XOP) File(cid string) (XOPFile, bool) {
+	cid = normalizeContentId(cid)
+	for _, file := range x.Files {
+		if file.ContentId == cid {
+			return file, true
+		}
+	}
+	for x.reader != nil {
+		file, err := x.NextFile()
+		if err != nil {
+			return XOPFile{}, false
+		}
+		if file.ContentId == cid {
+			return file, true
+		}
+	}
+	return XOPFile{}, false
+}
+
+// Buffer reads files that are not io.Seekers into memory,
+// so that they stay readable after the underlying reader, like a response body, is closed.
+// Attachments not yet read by NextFile are read as well.
+func (x *XOP) Buffer() error {
+	for i := range x.Files {
+		if err := x.bufferFile(i); err != nil {
+			return WrapError(err)
+		}
+	}
+	// a lazily read part is only readable until the next one is read
+	for x.reader != nil {
+		if _, err := x.readNextFile(); err == io.EOF {
+			break
+		} else if err != nil {
+			return WrapError(err)
+		}
+		if err := x.bufferFile(len(x.Files) - 1); err != nil {
+			return WrapError(err)
+		}
+	}
+	return nil
+}
+
+func (x *XOP) bufferFile(i int) error {
+	if _, ok := x.Files[i].File.(io.Seeker); ok {
+		return nil
+	}
+	b, err := ioutil.ReadAll(x.Files[i].File)
+	if err != nil {
+		return WrapError(err)
+	}
+	x.Files[i].File = bytes.NewReader(b)
+	return nil
+}
+
+// prepareReplay makes all files rewindable, so that the XOP can be written again.
+// Files that are not io.Seekers are read into memory.
+func (x *XOP) prepareReplay() error {
+	if err := x.Buffer(); err != nil {
+		return WrapError(err)
+	}
+	for i, file := range x.Files {
+		offset, err := file.File.(io.Seeker).Seek(0, io.SeekCurrent)
+		if err != nil {
+			return WrapError(err)
+		}
+		x.Files[i].offset = offset
+	}
+	return nil
+}
+
+// rewind seeks all files that are io.Seekers back to where they were in prepareReplay.
+func (x *XOP) rewind() error {
+	for _, file := range x.Files {
+		if s, ok := file.File.(io.Seeker); ok {
+			if _, err := s.Seek(file.offset, io.SeekStart); err != nil {
+				return WrapError(err)
+			}
+		}
+	}
+	return nil
 }
 
 func (x *XOP) ContentType() string {
+	if x.Format == XOPSwA {
+		return fmt.Sprintf(`multipart/related; type="text/xml"; boundary="%s"; start="<root>"`, x.Boundary)
+	}
 	return fmt.Sprintf(`multipart/related; type="application/xop+xml"; boundary="%s"; start="<root>"; start-info="text/xml"`, x.Boundary)
 }
 
@@ -97,50 +371,140 @@ func (x *XOP) WriteResponse(w http.ResponseWriter) (n int64, err error) {
 }
 
 func (x *XOP) WriteTo(w io.Writer) (n int64, err error) {
+	cw := &countingWriter{w: w}
+	err = x.writeTo(cw, true)
+	return cw.n, WrapError(err)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+
+// ContentLength returns the number of bytes WriteTo writes,
+// if the sizes of all files are known. See readerSize.
+func (x *XOP) ContentLength() (int64, bool) {
+	var filesLength int64
+	for _, file := range x.Files {
+		size, ok := readerSize(file.File)
+		if !ok {
+			return 0, false
+		}
+		filesLength += file.encoding().encodedSize(size)
+	}
+	cw := &countingWriter{w: ioutil.Discard}
+	if err := x.writeTo(cw, false); err != nil {
+		return 0, false
+	}
+	return cw.n + filesLength, true
+}
+
+// readerSize returns the number of bytes left to read from r,
+// if r tells it by a Len method like bytes.Reader, or r is an io.Seeker like os.File.
+func readerSize(r io.Reader) (int64, bool) {
+	switch r := r.(type) {
+	case interface{ Len() int }:
+		return int64(r.Len()), true
+	case io.Seeker:
+		cur, err := r.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return 0, false
+		}
+		end, err := r.Seek(0, io.SeekEnd)
+		if err != nil {
+			return 0, false
+		}
+		if _, err := r.Seek(cur, io.SeekStart); err != nil {
+			return 0, false
+		}
+		return end - cur, true
+	}
+	return 0, false
+}
+
+// writeTo writes the multipart message, leaving out the file contents if withFiles is false.
+func (x *XOP) writeTo(w io.Writer, withFiles bool) error {
 	mw := multipart.NewWriter(w)
 	mw.SetBoundary(x.Boundary)
 
 	h1 := make(textproto.MIMEHeader)
-	// same as seen in https://github.com/vrk-kpa/X-Road/blob/develop/doc/Protocols/pr-mess_x-road_message_protocol.md#annex-g-example-request-with-mtom-attachment
-	h1.Add("Content-Type", `application/xop+xml; charset=UTF-8; type="text/xml"`)
+	if x.Format == XOPSwA {
+		h1.Add("Content-Type", "text/xml; charset=UTF-8")
+	} else {
+		// same as seen in https://github.com/vrk-kpa/X-Road/blob/develop/doc/Protocols/pr-mess_x-road_message_protocol.md#annex-g-example-request-with-mtom-attachment
+		h1.Add("Content-Type", `application/xop+xml; charset=UTF-8; type="text/xml"`)
+	}
 	h1.Add("Content-Transfer-Encoding", "8bit")
 	h1.Add("Content-ID", "<root>")
 	root, err := mw.CreatePart(h1)
 	if err != nil {
-		return 0, WrapError(err)
+		return WrapError(err)
 	}
 
 	enc := xml.NewEncoder(root)
 	if err := enc.Encode(x.SOAPEnvelope); err != nil {
-		return 0, WrapError(err)
+		return WrapError(err)
 	}
 
 	for _, file := range x.Files {
 		h2 := make(textproto.MIMEHeader)
 		// same as seen in https://github.com/vrk-kpa/X-Road/blob/develop/doc/Protocols/pr-mess_x-road_message_protocol.md#annex-g-example-request-with-mtom-attachment
 		h2.Add("Content-Type", fmt.Sprintf("application/octet-stream; name=%s", file.Filename))
-		h2.Add("Content-Transfer-Encoding", "base64")
-		h2.Add("Content-ID", file.ContentId)
+		h2.Add("Content-Transfer-Encoding", string(file.encoding()))
+		h2.Add("Content-ID", fmt.Sprintf("<%s>", file.ContentId))
 		h2.Add("Content-Disposition", fmt.Sprintf(`attachment;name="%s";filename="%s"`, file.Filename, file.Filename))
 		part, err := mw.CreatePart(h2)
 		if err != nil {
-			return 0, WrapError(err)
+			return WrapError(err)
+		}
+		if !withFiles {
+			continue
+		}
+		if file.encoding() != TransferEncodingBase64 {
+			if _, err := io.Copy(part, file.File); err != nil {
+				return WrapError(err)
+			}
+			continue
 		}
 		enc := base64.NewEncoder(base64.StdEncoding, part)
 		if _, err := io.Copy(enc, file.File); err != nil {
-			return 0, WrapError(err)
+			return WrapError(err)
+		}
+		if err := enc.Close(); err != nil {
+			return WrapError(err)
 		}
-		enc.Close()
 	}
 	if err := mw.Close(); err != nil {
-		return 0, WrapError(err)
+		return WrapError(err)
 	}
 
-	return 0, nil
+	return nil
 }
 
 func NewXOPFromReader(contentType string, r io.Reader, envelope *SOAPEnvelope) (*XOP, error) {
-	x := &XOP{}
+	x, err := NewXOPFromReaderWithOptions(contentType, r, envelope, DecodeOptions{})
+	return x, WrapError(err)
+}
+
+// NewXOPFromReaderWithOptions parses the SOAP part into envelope,
+// and reads the attachments as opts.Attachments tells.
+func NewXOPFromReaderWithOptions(contentType string, r io.Reader, envelope *SOAPEnvelope, opts DecodeOptions) (*XOP, error) {
+	x, err := newXOPFromReader(contentType, r, envelope, opts, false)
+	return x, WrapError(err)
+}
+
+// newXOPFromReader is NewXOPFromReaderWithOptions, which returns a Fault in the SOAP part
+// as a SOAPFault error if faults is set, like DecodeResponse.
+func newXOPFromReader(contentType string, r io.Reader, envelope *SOAPEnvelope, opts DecodeOptions, faults bool) (*XOP, error) {
+	x := &XOP{
+		options: opts,
+	}
 
 	mediaType, params, err := mime.ParseMediaType(contentType)
 	if err != nil {
@@ -155,35 +519,162 @@ func NewXOPFromReader(contentType string, r io.Reader, envelope *SOAPEnvelope) (
 		return x, WrapError(fmt.Errorf("mediaType does not look like multipart"))
 	}
 
-	mr := multipart.NewReader(r, params["boundary"])
+	x.total = newLimitReader(r, "MaxTotalSize", opts.MaxTotalSize)
+	x.reader = multipart.NewReader(x.total, params["boundary"])
 
-	first := true
+	// the first part is the SOAP message
+	part, err := x.reader.NextPart()
+	if err != nil {
+		return x, WrapError(x.total.cause(err))
+	}
+	x.Format = detectXOPFormat(params["type"], part.Header.Get("Content-Type"))
+	lr := newLimitReader(part, "MaxEnvelopeSize", opts.MaxEnvelopeSize)
+	lr.outer = x.total
+	b, err := ioutil.ReadAll(lr)
+	if err != nil {
+		return x, WrapError(lr.cause(err))
+	}
+	if faults {
+		if err := decodeFault(b, envelope); err != nil {
+			return x, WrapError(err)
+		}
+	}
+	if err := xml.Unmarshal(b, envelope); err != nil {
+		return x, WrapError(err)
+	}
+	if opts.keepRaw {
+		envelope.raw = b
+	}
+	x.SOAPEnvelope = *envelope
+
+	if opts.Attachments == AttachmentsLazy {
+		return x, nil
+	}
 	for {
-		part, err := mr.NextPart()
-		if err == io.EOF {
+		if _, err := x.readNextFile(); err == io.EOF {
 			return x, nil
+		} else if err != nil {
+			// the XOP is not returned to the caller, remove the attachments spooled so far
+			x.Close()
+			return x, WrapError(err)
+		}
+	}
+}
+
+// detectXOPFormat tells SwA from MTOM by the type parameter of the multipart message,
+// or by the Content-Type of the SOAP part if the type is missing or unknown.
+// Anything else is taken for MTOM.
+func detectXOPFormat(typ, rootContentType string) XOPFormat {
+	root, _, _ := mime.ParseMediaType(rootContentType)
+	for _, t := range []string{typ, root} {
+		switch strings.ToLower(t) {
+		case "application/xop+xml":
+			return XOPMTOM
+		case "text/xml":
+			return XOPSwA
 		}
+	}
+	return XOPMTOM
+}
+
+// readNextFile reads the next part into Files, as x.options.Attachments tells.
+func (x *XOP) readNextFile() (XOPFile, error) {
+	part, err := x.reader.NextPart()
+	if err == io.EOF {
+		x.reader = nil
+		return XOPFile{}, io.EOF
+	}
+	if err != nil {
+		return XOPFile{}, WrapError(x.total.cause(err))
+	}
+	if max := x.options.MaxAttachments; max > 0 && int64(len(x.Files)) >= max {
+		x.reader = nil
+		return XOPFile{}, WrapError(LimitError{
+			Limit: "MaxAttachments",
+			Max:   max,
+		})
+	}
+	file := XOPFile{
+		ContentId: normalizeContentId(part.Header.Get("Content-ID")),
+		Filename:  part.FileName(),
+	}
+	lr := newLimitReader(part, "MaxAttachmentSize", x.options.MaxAttachmentSize)
+	lr.outer = x.total
+	r, err := decodeTransferEncoding(part.Header.Get("Content-Transfer-Encoding"), lr)
+	if err != nil {
+		return file, WrapError(err)
+	}
+	file.Encoding = TransferEncoding(strings.ToLower(part.Header.Get("Content-Transfer-Encoding")))
+
+	switch x.options.Attachments {
+	case AttachmentsLazy:
+		// the part is only readable until the next part is requested
+		file.File = r
+	case AttachmentsSpooled:
+		f, err := ioutil.TempFile(x.options.SpoolDir, "xroad-xop-")
 		if err != nil {
-			return x, WrapError(err)
+			return file, WrapError(err)
 		}
-		if first {
-			first = false
-			b, err := ioutil.ReadAll(part)
-			if err != nil {
-				return x, WrapError(err)
-			}
-			if err := xml.Unmarshal(b, envelope); err != nil {
-				return x, WrapError(err)
-			}
-			x.SOAPEnvelope = *envelope
-		} else {
-			_, err = x.AddFile(part.FileName(), part)
-			if err != nil {
-				return nil, WrapError(err)
-			}
-			// only allow one xopFile
-			break
+		x.spooled = append(x.spooled, f)
+		if _, err := io.Copy(f, r); err != nil {
+			return file, WrapError(lr.cause(err))
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return file, WrapError(err)
+		}
+		file.File = f
+	default:
+		b, err := ioutil.ReadAll(r)
+		if err != nil {
+			return file, WrapError(lr.cause(err))
+		}
+		file.File = bytes.NewReader(b)
+	}
+	x.Files = append(x.Files, file)
+	return file, nil
+}
+
+// decodeTransferEncoding returns a reader of the decoded part.
+// quoted-printable is decoded by mime/multipart, which also removes the header.
+func decodeTransferEncoding(encoding string, r io.Reader) (io.Reader, error) {
+	switch strings.ToLower(encoding) {
+	case "base64":
+		// the decoder skips line breaks
+		return base64.NewDecoder(base64.StdEncoding, r), nil
+	case "", "binary", "8bit", "7bit":
+		return r, nil
+	}
+	return nil, WrapError(fmt.Errorf("unsupported Content-Transfer-Encoding: %s", encoding))
+}
+
+// NextFile returns the attachments one by one, and io.EOF after the last one.
+// When decoded with AttachmentsLazy, attachments are read from the message as NextFile is called,
+// and the File of an attachment is only readable until NextFile is called again.
+func (x *XOP) NextFile() (XOPFile, error) {
+	if x.next < len(x.Files) {
+		x.next++
+		return x.Files[x.next-1], nil
+	}
+	if x.reader == nil {
+		return XOPFile{}, io.EOF
+	}
+	file, err := x.readNextFile()
+	if err != nil {
+		return file, err
+	}
+	x.next++
+	return file, nil
+}
+
+// Close removes the temporary files of attachments decoded with AttachmentsSpooled.
+func (x *XOP) Close() error {
+	var ret error
+	for _, f := range x.spooled {
+		f.Close()
+		if err := os.Remove(f.Name()); err != nil && ret == nil {
+			ret = WrapError(err)
 		}
 	}
-	return x, nil
+	x.spooled = nil
+	return ret
 }
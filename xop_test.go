@@ -0,0 +1,524 @@
+package xroad
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+type testFilesBody struct {
+	Includes []XOPInclude `xml:"http://www.w3.org/2004/08/xop/include Include"`
+}
+
+func (b *testFilesBody) IncludeFiles(cids []string) {
+	for _, cid := range cids {
+		b.Includes = append(b.Includes, XOPInclude{Href: "cid:" + cid})
+	}
+}
+
+func TestXOPMultipleFiles(t *testing.T) {
+	x, err := NewXOP()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	cids, err := x.AddFiles(
+		XOPFile{Filename: "a.txt", File: strings.NewReader("first")},
+		XOPFile{ContentId: "second@example.com", Filename: "b.txt", File: strings.NewReader("second")},
+	)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	body := &testFilesBody{}
+	includeFiles(body, cids)
+	x.SOAPEnvelope = NewEnvelope(SOAPHeader{}, body)
+
+	var buf bytes.Buffer
+	if _, err := x.WriteTo(&buf); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	var e SOAPEnvelope
+	e.Body = &testFilesBody{}
+	if err := DecodeReader(&buf, x.ContentType(), &e); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(e.XOP.Files) != 2 {
+		t.Fatalf("expected 2 files, got %d", len(e.XOP.Files))
+	}
+	decoded := e.Body.(*testFilesBody)
+	if decoded.Includes[1].Href != "cid:second@example.com" {
+		t.Errorf("expected the given content id, got %s", decoded.Includes[1].Href)
+	}
+	for i, want := range []struct{ filename, content string }{{"a.txt", "first"}, {"b.txt", "second"}} {
+		file, ok := e.XOP.File(decoded.Includes[i].Href)
+		if !ok {
+			t.Fatalf("file %s not found by %s", want.filename, decoded.Includes[i].Href)
+		}
+		if file.Filename != want.filename {
+			t.Errorf("expected %s, got %s", want.filename, file.Filename)
+		}
+		b, err := ioutil.ReadAll(file.File)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if string(b) != want.content {
+			t.Errorf("%s: expected %q, got %q", want.filename, want.content, b)
+		}
+	}
+}
+
+func TestXOPContentLength(t *testing.T) {
+	x, err := NewXOP()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	x.AddFile("a.bin", bytes.NewReader(make([]byte, 1000)))
+	x.AddFile("b.bin", strings.NewReader("odd"))
+	x.SOAPEnvelope = NewEnvelope(SOAPHeader{}, &testFilesBody{})
+
+	length, ok := x.ContentLength()
+	if !ok {
+		t.Fatalf("expected the length to be known")
+	}
+	n, err := x.WriteTo(ioutil.Discard)
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	if n != length {
+		t.Errorf("ContentLength %d, WriteTo wrote %d", length, n)
+	}
+}
+
+func TestXOPDecodeAttachmentModes(t *testing.T) {
+	for _, mode := range []AttachmentMode{AttachmentsInMemory, AttachmentsLazy, AttachmentsSpooled} {
+		x, err := NewXOP()
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		x.AddFile("a.txt", strings.NewReader("first"))
+		x.AddFile("b.txt", strings.NewReader("second"))
+		x.SOAPEnvelope = NewEnvelope(SOAPHeader{}, &testFilesBody{})
+		var buf bytes.Buffer
+		if _, err := x.WriteTo(&buf); err != nil {
+			t.Fatalf("%s", err)
+		}
+
+		var e SOAPEnvelope
+		e.Body = &testFilesBody{}
+		opts := DecodeOptions{Attachments: mode, SpoolDir: t.TempDir()}
+		if err := DecodeReaderWithOptions(&buf, x.ContentType(), &e, opts); err != nil {
+			t.Fatalf("mode %d: %s", mode, err)
+		}
+		for _, filename := range []string{"a.txt", "b.txt"} {
+			file, err := e.XOP.NextFile()
+			if err != nil {
+				t.Fatalf("mode %d: %s", mode, err)
+			}
+			if file.Filename != filename {
+				t.Errorf("mode %d: expected %s, got %s", mode, filename, file.Filename)
+			}
+			if _, err := ioutil.ReadAll(file.File); err != nil {
+				t.Fatalf("mode %d: %s", mode, err)
+			}
+		}
+		if _, err := e.XOP.NextFile(); err != io.EOF {
+			t.Errorf("mode %d: expected io.EOF, got %v", mode, err)
+		}
+		if err := e.XOP.Close(); err != nil {
+			t.Errorf("mode %d: %s", mode, err)
+		}
+	}
+}
+
+func TestXOPDecodeLimits(t *testing.T) {
+	x, err := NewXOP()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	x.AddFile("a.txt", strings.NewReader("first"))
+	x.AddFile("b.txt", strings.NewReader("second"))
+	x.SOAPEnvelope = NewEnvelope(SOAPHeader{}, &testFilesBody{})
+	var buf bytes.Buffer
+	if _, err := x.WriteTo(&buf); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	tests := []struct {
+		opts  DecodeOptions
+		limit string
+	}{
+		{DecodeOptions{MaxEnvelopeSize: 10}, "MaxEnvelopeSize"},
+		{DecodeOptions{MaxAttachmentSize: 4}, "MaxAttachmentSize"},
+		{DecodeOptions{MaxAttachments: 1}, "MaxAttachments"},
+		{DecodeOptions{MaxTotalSize: int64(buf.Len() - 1)}, "MaxTotalSize"},
+		{DecodeOptions{MaxAttachmentSize: 4, Attachments: AttachmentsSpooled, SpoolDir: t.TempDir()}, "MaxAttachmentSize"},
+	}
+	for _, test := range tests {
+		var e SOAPEnvelope
+		e.Body = &testFilesBody{}
+		err := DecodeReaderWithOptions(bytes.NewReader(buf.Bytes()), x.ContentType(), &e, test.opts)
+		if e.XOP != nil {
+			e.XOP.Close()
+		}
+		var le LimitError
+		if !errors.As(err, &le) {
+			t.Errorf("%s: expected a LimitError, got %v", test.limit, err)
+			continue
+		}
+		if le.Limit != test.limit {
+			t.Errorf("expected %s, got %s", test.limit, le.Limit)
+		}
+	}
+
+	// exactly at the limit
+	var e SOAPEnvelope
+	e.Body = &testFilesBody{}
+	opts := DecodeOptions{MaxTotalSize: int64(buf.Len()), MaxAttachments: 2}
+	if err := DecodeReaderWithOptions(bytes.NewReader(buf.Bytes()), x.ContentType(), &e, opts); err != nil {
+		t.Errorf("%s", err)
+	}
+
+	// lazily read attachments fail when read
+	e = SOAPEnvelope{Body: &testFilesBody{}}
+	opts = DecodeOptions{MaxAttachmentSize: 4, Attachments: AttachmentsLazy}
+	if err := DecodeReaderWithOptions(bytes.NewReader(buf.Bytes()), x.ContentType(), &e, opts); err != nil {
+		t.Fatalf("%s", err)
+	}
+	file, err := e.XOP.NextFile()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	var le LimitError
+	if _, err := ioutil.ReadAll(file.File); !errors.As(err, &le) {
+		t.Errorf("expected a LimitError, got %v", err)
+	}
+}
+
+func TestXOPDecodeSpoolCleanup(t *testing.T) {
+	x, err := NewXOP()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	x.AddFile("a.txt", strings.NewReader("first"))
+	x.AddFile("b.txt", strings.NewReader("second attachment"))
+	x.SOAPEnvelope = NewEnvelope(SOAPHeader{}, &testFilesBody{})
+	var buf bytes.Buffer
+	if _, err := x.WriteTo(&buf); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	// not t.TempDir, which would remove leaked files
+	dir, err := ioutil.TempDir("", "xroad-spool")
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the first attachment is spooled before the second one fails
+	for _, opts := range []DecodeOptions{
+		{MaxAttachmentSize: 10},
+		{MaxAttachments: 1},
+		{MaxTotalSize: int64(buf.Len() - 10)},
+	} {
+		opts.Attachments = AttachmentsSpooled
+		opts.SpoolDir = dir
+		var e SOAPEnvelope
+		e.Body = &testFilesBody{}
+		err := DecodeReaderWithOptions(bytes.NewReader(buf.Bytes()), x.ContentType(), &e, opts)
+		var le LimitError
+		if !errors.As(err, &le) {
+			t.Fatalf("%+v: expected a LimitError, got %v", opts, err)
+		}
+		if e.XOP != nil {
+			t.Errorf("%+v: expected no XOP on error", opts)
+		}
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if len(files) != 0 {
+			t.Errorf("%s: %d spooled files left", le.Limit, len(files))
+		}
+	}
+}
+
+func TestXOPBuffer(t *testing.T) {
+	x, err := NewXOP()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	contents := []string{"first", "second", "third"}
+	for i, content := range contents {
+		x.AddFile(fmt.Sprintf("%d.txt", i), strings.NewReader(content))
+	}
+	x.SOAPEnvelope = NewEnvelope(SOAPHeader{}, &testFilesBody{})
+	var buf bytes.Buffer
+	if _, err := x.WriteTo(&buf); err != nil {
+		t.Fatalf("%s", err)
+	}
+
+	var e SOAPEnvelope
+	e.Body = &testFilesBody{}
+	opts := DecodeOptions{Attachments: AttachmentsLazy}
+	if err := DecodeReaderWithOptions(bytes.NewReader(buf.Bytes()), x.ContentType(), &e, opts); err != nil {
+		t.Fatalf("%s", err)
+	}
+	// the first attachment is being read when the rest are buffered
+	if _, err := e.XOP.NextFile(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if err := e.XOP.Buffer(); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if len(e.XOP.Files) != len(contents) {
+		t.Fatalf("expected %d files, got %d", len(contents), len(e.XOP.Files))
+	}
+	for i, content := range contents {
+		b, err := ioutil.ReadAll(e.XOP.Files[i].File)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if string(b) != content {
+			t.Errorf("file %d: expected %q, got %q", i, content, b)
+		}
+	}
+}
+
+func TestXOPTransferEncodings(t *testing.T) {
+	payload := make([]byte, 256)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	for _, encoding := range []TransferEncoding{"", TransferEncodingBase64, TransferEncodingBinary, TransferEncoding8bit} {
+		x, err := NewXOP()
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		x.AddFiles(XOPFile{Filename: "a.bin", File: bytes.NewReader(payload), Encoding: encoding})
+		x.SOAPEnvelope = NewEnvelope(SOAPHeader{}, &testFilesBody{})
+		length, _ := x.ContentLength()
+		var buf bytes.Buffer
+		n, err := x.WriteTo(&buf)
+		if err != nil {
+			t.Fatalf("%s", err)
+		}
+		if n != length {
+			t.Errorf("%q: ContentLength %d, WriteTo wrote %d", encoding, length, n)
+		}
+
+		var e SOAPEnvelope
+		e.Body = &testFilesBody{}
+		if err := DecodeReader(&buf, x.ContentType(), &e); err != nil {
+			t.Fatalf("%q: %s", encoding, err)
+		}
+		file := e.XOP.Files[0]
+		b, err := ioutil.ReadAll(file.File)
+		if err != nil {
+			t.Fatalf("%q: %s", encoding, err)
+		}
+		if !bytes.Equal(b, payload) {
+			t.Errorf("%q: attachment changed in the round trip", encoding)
+		}
+		if file.encoding() != x.Files[0].encoding() {
+			t.Errorf("%q: decoded as %s", encoding, file.Encoding)
+		}
+	}
+}
+
+// modelled on the X-Road message protocol Annex G, example request with MTOM attachment
+const annexGRequest = `--MIME_boundary
+Content-Type: application/xop+xml; charset=UTF-8; type="text/xml"
+Content-Transfer-Encoding: 8bit
+Content-ID: <rootpart@example.org>
+
+<?xml version="1.0" encoding="UTF-8"?>
+<SOAP-ENV:Envelope
+        xmlns:SOAP-ENV="http://schemas.xmlsoap.org/soap/envelope/"
+        xmlns:xrd="http://x-road.eu/xsd/xroad.xsd"
+        xmlns:id="http://x-road.eu/xsd/identifiers"
+        xmlns:ns1="http://test.x-road.fi/producer"
+        xmlns:xop="http://www.w3.org/2004/08/xop/include">
+    <SOAP-ENV:Header>
+        <xrd:client id:objectType="SUBSYSTEM">
+            <id:xRoadInstance>EE</id:xRoadInstance>
+            <id:memberClass>GOV</id:memberClass>
+            <id:memberCode>MEMBER1</id:memberCode>
+            <id:subsystemCode>SUBSYSTEM1</id:subsystemCode>
+        </xrd:client>
+        <xrd:service id:objectType="SERVICE">
+            <id:xRoadInstance>EE</id:xRoadInstance>
+            <id:memberClass>GOV</id:memberClass>
+            <id:memberCode>MEMBER2</id:memberCode>
+            <id:subsystemCode>SUBSYSTEM2</id:subsystemCode>
+            <id:serviceCode>sendAttachment</id:serviceCode>
+            <id:serviceVersion>v1</id:serviceVersion>
+        </xrd:service>
+        <xrd:id>4894e35d-bf0f-44a6-867a-8e51f1daa7e0</xrd:id>
+        <xrd:userId>EE12345678901</xrd:userId>
+        <xrd:protocolVersion>4.0</xrd:protocolVersion>
+    </SOAP-ENV:Header>
+    <SOAP-ENV:Body>
+        <ns1:sendAttachment>
+            <ns1:attachment>
+                <xop:Include href="cid:attachment@example.org"/>
+            </ns1:attachment>
+        </ns1:sendAttachment>
+    </SOAP-ENV:Body>
+</SOAP-ENV:Envelope>
+--MIME_boundary
+Content-Type: application/octet-stream
+Content-Transfer-Encoding: binary
+Content-ID: <attachment@example.org>
+
+Hello, X-Road!
+--MIME_boundary--
+`
+
+type testAnnexGBody struct {
+	SendAttachment struct {
+		Attachment XOPElement `xml:"http://test.x-road.fi/producer attachment"`
+	} `xml:"http://test.x-road.fi/producer sendAttachment"`
+}
+
+func TestXOPAnnexG(t *testing.T) {
+	contentType := `multipart/related; type="application/xop+xml"; boundary="MIME_boundary"; start="<rootpart@example.org>"; start-info="text/xml"`
+	message := strings.ReplaceAll(annexGRequest, "\n", "\r\n")
+
+	for round := 0; round < 2; round++ {
+		var e SOAPEnvelope
+		e.Body = &testAnnexGBody{}
+		if err := DecodeReader(strings.NewReader(message), contentType, &e); err != nil {
+			t.Fatalf("round %d: %s", round, err)
+		}
+		if e.Header.Service.ServiceCode != "sendAttachment" || e.Header.Client.MemberCode != "MEMBER1" {
+			t.Errorf("round %d: unexpected header %+v", round, e.Header)
+		}
+		body := e.Body.(*testAnnexGBody)
+		file, ok := e.XOP.File(body.SendAttachment.Attachment.ContentId())
+		if !ok {
+			t.Fatalf("round %d: attachment not found", round)
+		}
+		b, err := ioutil.ReadAll(file.File)
+		if err != nil {
+			t.Fatalf("round %d: %s", round, err)
+		}
+		if string(b) != "Hello, X-Road!" {
+			t.Errorf("round %d: unexpected attachment %q", round, b)
+		}
+		if file.Encoding != TransferEncodingBinary {
+			t.Errorf("round %d: expected binary, got %s", round, file.Encoding)
+		}
+
+		// encode what was decoded, and decode it again in the next round
+		file.File = bytes.NewReader(b)
+		e.XOP.Files = []XOPFile{file}
+		var buf bytes.Buffer
+		if _, err := e.XOP.WriteTo(&buf); err != nil {
+			t.Fatalf("round %d: %s", round, err)
+		}
+		message = buf.String()
+		contentType = e.XOP.ContentType()
+	}
+}
+
+type testSwABody struct {
+	Attachment struct {
+		Href string `xml:"href,attr"`
+	} `xml:"http://example.com attachment"`
+}
+
+func (b *testSwABody) IncludeFile(cid string) {
+	b.Attachment.Href = "cid:" + cid
+}
+
+func TestXOPSwA(t *testing.T) {
+	x, err := NewXOP()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	x.Format = XOPSwA
+	cids, err := x.AddFiles(XOPFile{ContentId: "a@example.com", Filename: "a.txt", File: strings.NewReader("first")})
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	body := &testSwABody{}
+	includeFiles(body, cids)
+	x.SOAPEnvelope = NewEnvelope(SOAPHeader{}, body)
+	if !strings.Contains(x.ContentType(), `type="text/xml"`) {
+		t.Errorf("unexpected Content-Type %s", x.ContentType())
+	}
+	var buf bytes.Buffer
+	if _, err := x.WriteTo(&buf); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if strings.Contains(buf.String(), "application/xop+xml") {
+		t.Errorf("SwA message with a application/xop+xml part")
+	}
+
+	// the format is detected by the type parameter, and without it by the SOAP part
+	for _, contentType := range []string{x.ContentType(), fmt.Sprintf(`multipart/related; boundary="%s"`, x.Boundary)} {
+		var e SOAPEnvelope
+		e.Body = &testSwABody{}
+		if err := DecodeReader(bytes.NewReader(buf.Bytes()), contentType, &e); err != nil {
+			t.Fatalf("%s", err)
+		}
+		if e.XOP.Format != XOPSwA {
+			t.Errorf("expected SwA, got %s", e.XOP.Format)
+		}
+		file, ok := e.XOP.File(e.Body.(*testSwABody).Attachment.Href)
+		if !ok {
+			t.Fatalf("attachment not found")
+		}
+		b, _ := ioutil.ReadAll(file.File)
+		if string(b) != "first" {
+			t.Errorf("unexpected attachment %q", b)
+		}
+	}
+}
+
+func TestDetectXOPFormat(t *testing.T) {
+	tests := []struct {
+		typ, root string
+		want      XOPFormat
+	}{
+		{"application/xop+xml", "", XOPMTOM},
+		{"text/xml", "", XOPSwA},
+		{"", `application/xop+xml; charset=UTF-8; type="text/xml"`, XOPMTOM},
+		{"", "text/xml; charset=UTF-8", XOPSwA},
+		{"application/soap+xml", "text/xml", XOPSwA},
+		// unknown formats are taken for MTOM
+		{"application/soap+xml", "", XOPMTOM},
+		{"", "", XOPMTOM},
+	}
+	for _, test := range tests {
+		if got := detectXOPFormat(test.typ, test.root); got != test.want {
+			t.Errorf("%q %q: expected %s, got %s", test.typ, test.root, test.want, got)
+		}
+	}
+
+	// a message of an unknown type is decoded
+	x, err := NewXOP()
+	if err != nil {
+		t.Fatalf("%s", err)
+	}
+	x.AddFile("a.txt", strings.NewReader("first"))
+	x.SOAPEnvelope = NewEnvelope(SOAPHeader{}, &testFilesBody{})
+	var buf bytes.Buffer
+	if _, err := x.WriteTo(&buf); err != nil {
+		t.Fatalf("%s", err)
+	}
+	var e SOAPEnvelope
+	e.Body = &testFilesBody{}
+	contentType := fmt.Sprintf(`multipart/related; boundary="%s"; type="application/octet-stream"`, x.Boundary)
+	if err := DecodeReader(&buf, contentType, &e); err != nil {
+		t.Fatalf("%s", err)
+	}
+	if e.XOP.Format != XOPMTOM || len(e.XOP.Files) != 1 {
+		t.Errorf("unexpected XOP %s with %d files", e.XOP.Format, len(e.XOP.Files))
+	}
+}